@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brutella/hc/accessory"
+)
+
+// The bridge is always accessory 1, HomeKit requires it.
+const bridgeAccessoryID = 1
+
+// Preferred IDs for accessories there's only one of. Circuits prefer their controller circuit ID,
+// which starts at 500, so these never collide with them.
+var fixedAccessoryIDs = map[string]uint64{
+	"air_temperature":   2,
+	"pool":              3,
+	"spa":               4,
+	"freeze_protection": 5,
+	"service_mode":      6,
+	"delay":             7,
+	"chemistry_alarm":   8,
+	"low_salt":          9,
+}
+
+const accessoryIDsFile = "accessory_ids.json"
+
+// AccessoryIDs - assigns every accessory a HomeKit accessory ID (AID) that stays the same across
+// restarts, no matter which other accessories are exposed or what order they're created in.
+// HomeKit keys room assignments and automations off of the AID, so it must never change.
+//
+// IDs are keyed by the gateway's MAC address and what the accessory is (the pool, circuit 505, etc),
+// and every ID ever handed out is saved to a file in the HomeKit storage path. A circuit that's renamed
+// keeps its ID, and one that's removed keeps its ID reserved in case it comes back.
+type AccessoryIDs struct {
+	path    string
+	macAddr string
+	ids     map[string]uint64
+	dirty   bool
+}
+
+// LoadAccessoryIDs - loads the saved IDs from dir, if there are any.
+func LoadAccessoryIDs(dir string, macAddr string) (*AccessoryIDs, error) {
+	ai := &AccessoryIDs{
+		path:    filepath.Join(dir, accessoryIDsFile),
+		macAddr: strings.ToLower(macAddr),
+		ids:     make(map[string]uint64),
+	}
+
+	data, err := ioutil.ReadFile(ai.path)
+	if os.IsNotExist(err) {
+		return ai, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &ai.ids)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ai.path, err)
+	}
+
+	return ai, nil
+}
+
+func (ai *AccessoryIDs) key(name string) string {
+	return ai.macAddr + "/" + name
+}
+
+func (ai *AccessoryIDs) inUse(id uint64) bool {
+	if id == bridgeAccessoryID {
+		return true
+	}
+
+	for _, used := range ai.ids {
+		if used == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (ai *AccessoryIDs) next() uint64 {
+	max := uint64(bridgeAccessoryID)
+
+	for _, used := range ai.ids {
+		if used > max {
+			max = used
+		}
+	}
+
+	return max + 1
+}
+
+// ID - returns the ID for the accessory called name, handing out a new one if it hasn't been seen before.
+// preferred is used for new accessories if nothing else has it.
+func (ai *AccessoryIDs) ID(name string, preferred uint64) uint64 {
+	key := ai.key(name)
+
+	id, ok := ai.ids[key]
+	if ok {
+		return id
+	}
+
+	id = preferred
+	if id == 0 || ai.inUse(id) {
+		id = ai.next()
+	}
+
+	ai.ids[key] = id
+	ai.dirty = true
+
+	return id
+}
+
+// Assign - sets the ID of acc, which must be done before it's added to a transport.
+func (ai *AccessoryIDs) Assign(acc *accessory.Accessory, name string) {
+	acc.ID = ai.ID(name, fixedAccessoryIDs[name])
+}
+
+// AssignCircuit - sets the ID of the switch for a controller circuit.
+func (ai *AccessoryIDs) AssignCircuit(acc *accessory.Accessory, circuitID uint32) {
+	acc.ID = ai.ID(fmt.Sprintf("circuit/%d", circuitID), uint64(circuitID))
+}
+
+// Save - writes the IDs back to disk, if any new ones were handed out.
+func (ai *AccessoryIDs) Save() error {
+	if !ai.dirty {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(ai.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(ai.ids, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can't leave us with half a mapping,
+	// which would reshuffle everything on the next start.
+	tmp := ai.path + ".tmp"
+
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, ai.path)
+	if err != nil {
+		return err
+	}
+
+	ai.dirty = false
+
+	return nil
+}
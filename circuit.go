@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+)
+
+// maxHomeKitDuration - the most SetDuration and RemainingDuration can be. It's the limit in the HomeKit spec,
+// and what the Home app and others expect, even though the controller's egg timer goes up to 12 hours. Longer
+// egg timers show as an hour, and can only be set with slctl runtime.
+const maxHomeKitDuration = time.Hour
+
+// CircuitAccessory - a switch for turning a single controller circuit (lights, cleaner, etc) on and off.
+type CircuitAccessory struct {
+	*accessory.Switch
+
+	setDuration       *characteristic.SetDuration
+	remainingDuration *characteristic.RemainingDuration
+
+	circuitID uint32
+
+	client *Client
+}
+
+func NewCircuitAccessory(client *Client, circuitID uint32, name string, manufacturer string) (*CircuitAccessory, error) {
+	info := accessory.Info{
+		Name:         name,
+		Manufacturer: manufacturer,
+	}
+
+	circuit := &CircuitAccessory{
+		Switch: accessory.NewSwitch(info),
+
+		circuitID: circuitID,
+
+		client: client,
+	}
+
+	on, err := client.GetCircuitState(circuitID)
+	if err != nil {
+		return nil, err
+	}
+
+	logName := fmt.Sprintf("circuit %d", circuitID)
+
+	circuit.Switch.Switch.On.SetValue(on)
+	circuit.Switch.Switch.On.OnValueRemoteGet(remoteGetBool(logName, circuit.Switch.Switch.On.Bool, circuit.getState))
+	circuit.Switch.Switch.On.OnValueRemoteUpdate(circuit.setState)
+
+	// The egg timer: how long the circuit stays on for.
+	circuit.setDuration = characteristic.NewSetDuration()
+	circuit.setDuration.SetMaxValue(int(maxHomeKitDuration / time.Second))
+
+	duration, err := circuit.getDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	circuit.setDuration.SetValue(duration)
+	circuit.setDuration.OnValueRemoteGet(remoteGetInt(logName, circuit.setDuration.Int, circuit.getDuration))
+	circuit.setDuration.OnValueRemoteUpdate(circuit.setRuntime)
+	circuit.Switch.Switch.AddCharacteristic(circuit.setDuration.Characteristic)
+
+	circuit.remainingDuration = characteristic.NewRemainingDuration()
+	circuit.remainingDuration.SetMaxValue(int(maxHomeKitDuration / time.Second))
+
+	remaining, err := circuit.getRemaining()
+	if err != nil {
+		return nil, err
+	}
+
+	circuit.remainingDuration.SetValue(remaining)
+	circuit.remainingDuration.OnValueRemoteGet(remoteGetInt(logName, circuit.remainingDuration.Int, circuit.getRemaining))
+	circuit.Switch.Switch.AddCharacteristic(circuit.remainingDuration.Characteristic)
+
+	addStatusFault(circuit.Switch.Switch.Service, client)
+
+	return circuit, nil
+}
+
+func (ca *CircuitAccessory) getState() (bool, error) {
+	return ca.client.GetCircuitState(ca.circuitID)
+}
+
+func (ca *CircuitAccessory) setState(on bool) {
+	err := ca.client.SetCircuitState(ca.circuitID, on)
+	if err != nil {
+		log.Info.Printf("circuit %d: %v\n", ca.circuitID, err)
+		return
+	}
+
+	remaining, err := ca.getRemaining()
+	if err != nil {
+		log.Info.Printf("circuit %d: %v\n", ca.circuitID, err)
+		return
+	}
+
+	ca.remainingDuration.SetValue(remaining)
+}
+
+func (ca *CircuitAccessory) getDuration() (int, error) {
+	runtime, err := ca.client.GetCircuitRuntime(ca.circuitID)
+	if err != nil {
+		return 0, err
+	}
+
+	if runtime > maxHomeKitDuration {
+		runtime = maxHomeKitDuration
+	}
+
+	return int(runtime / time.Second), nil
+}
+
+func (ca *CircuitAccessory) getRemaining() (int, error) {
+	remaining, err := ca.client.GetCircuitRemaining(ca.circuitID)
+	if err != nil {
+		return 0, err
+	}
+
+	if remaining > maxHomeKitDuration {
+		remaining = maxHomeKitDuration
+	}
+
+	return int(remaining / time.Second), nil
+}
+
+// setRuntime - sets the egg timer from HomeKit. The controller works in whole minutes, so anything shorter than
+// a minute, including 0 from the very start of the slider, is set as a minute rather than refused.
+func (ca *CircuitAccessory) setRuntime(seconds int) {
+	runtime := time.Duration(seconds) * time.Second
+	if runtime < time.Minute {
+		runtime = time.Minute
+	}
+
+	err := ca.client.SetCircuitRuntime(ca.circuitID, runtime)
+	if err != nil {
+		log.Info.Printf("circuit %d: %v\n", ca.circuitID, err)
+	}
+}
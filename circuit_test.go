@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+func TestCircuitRemaining(t *testing.T) {
+	fg := newFakePool(t)
+
+	config := fakePoolConfig()
+	for i := range config.Circuits {
+		config.Circuits[i].DefaultRT = 45
+	}
+	fg.setConfig(config)
+
+	client := fg.newClient(ClientOptions{CacheExpiry: time.Nanosecond})
+
+	// The pool was already on before we started, so there's no telling how long it has left.
+	remaining, err := client.GetCircuitRemaining(505)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if remaining != 0 {
+		t.Errorf("%v left on a circuit that was on before we started, want 0", remaining)
+	}
+
+	err = client.SetCircuitState(501, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := fakePoolStatus()
+	status.Circuits[2].ValveState = 1
+	fg.setStatus(status)
+
+	remaining, err = client.GetCircuitRemaining(501)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if remaining <= 44*time.Minute || remaining > 45*time.Minute {
+		t.Errorf("%v left on a circuit we just turned on, want about 45m", remaining)
+	}
+}
+
+func TestCircuitRuntimeFromHomeKit(t *testing.T) {
+	fg := newFakePool(t)
+	client := fg.newClient(ClientOptions{})
+
+	circuit, err := NewCircuitAccessory(client, 501, "Pool Light", "Pentair")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := circuit.setDuration.GetMaxValue(); got != 3600 {
+		t.Errorf("SetDuration goes up to %d, want 3600", got)
+	}
+
+	tests := []struct {
+		seconds int
+		minutes uint32
+	}{
+		{0, 1},
+		{30, 1},
+		{45 * 60, 45},
+	}
+
+	for _, tt := range tests {
+		circuit.setRuntime(tt.seconds)
+
+		if got, want := fg.lastRequest(protocol.SetCircuitRuntimePacketCode), []uint32{0, 501, tt.minutes}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%ds: gateway got %v, want %v", tt.seconds, got, want)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/brianmario/screenlogic-homekit/screenlogic"
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
 	"github.com/brutella/hc/characteristic"
 	"github.com/brutella/hc/log"
 )
@@ -19,7 +23,17 @@ type Client struct {
 	gateway          *screenlogic.Gateway
 	requestMutex     sync.Mutex
 	clientName       string
+	gatewayAddress   string
+	remoteName       string
+	dispatcher       string
+	password         string
 	reconnectRetries uint8
+	keepalive        time.Duration
+	readOnly         bool
+	maxSetPoints     map[screenlogic.BodyOfWater]uint32
+	metrics          *clientMetrics
+	statusListeners  []StatusListener
+	circuitOnSince   map[uint32]time.Time // when each circuit that's on was first seen on
 	cache            struct {
 		defaultExpiry time.Duration
 		poolStatus    struct {
@@ -30,16 +44,72 @@ type Client struct {
 			last     *screenlogic.ControllerConfiguration
 			deadline int64
 		}
+		weatherForecast struct {
+			last *screenlogic.WeatherForecast
+
+			// The gateway can tell us the forecast changed while the keepalive is pinging it, without
+			// requestMutex held, so deadline has its own lock.
+			mutex    sync.Mutex
+			deadline int64
+		}
 	}
 }
 
-func NewConnectedClient(clientName string) (*Client, error) {
+// StatusListener - called every time Client fetches a fresh PoolStatus from the gateway. previous
+// is nil for the first status we fetch.
+//
+// Listeners are called while Client's request lock is held, so they must not call back into Client.
+type StatusListener func(previous, current *screenlogic.PoolStatus)
+
+type ClientOptions struct {
+	ClientName string
+
+	// GatewayAddress is host[:port]. If empty, the gateway is discovered on the local network.
+	GatewayAddress string
+	Password       string
+
+	// RemoteName, if set, is looked up through the dispatcher at Dispatcher instead.
+	RemoteName string
+	Dispatcher string
+
+	CacheExpiry      time.Duration
+	ReconnectRetries uint8
+
+	// Keepalive pings the gateway after it's been idle this long, so it doesn't drop the connection.
+	// Zero disables it.
+	Keepalive time.Duration
+
+	// ReadOnly refuses every request that would change anything on the controller.
+	ReadOnly bool
+
+	// Site specific limits on the set points, on top of the controller's own, in its units. Zero
+	// leaves it up to the controller.
+	MaxPoolSetPoint uint32
+	MaxSpaSetPoint  uint32
+}
+
+func NewConnectedClient(options ClientOptions) (*Client, error) {
 	client := &Client{
-		clientName:       clientName,
-		reconnectRetries: 1, // TODO: make this configurable?
+		clientName:       options.ClientName,
+		gatewayAddress:   options.GatewayAddress,
+		remoteName:       options.RemoteName,
+		dispatcher:       options.Dispatcher,
+		password:         options.Password,
+		reconnectRetries: options.ReconnectRetries,
+		keepalive:        options.Keepalive,
+		readOnly:         options.ReadOnly,
+		metrics:          newClientMetrics(),
+		circuitOnSince:   make(map[uint32]time.Time),
 	}
 
-	client.cache.defaultExpiry = time.Minute * 1
+	client.cache.defaultExpiry = options.CacheExpiry
+
+	client.maxSetPoints = map[screenlogic.BodyOfWater]uint32{
+		screenlogic.BodyOfWaterPool: options.MaxPoolSetPoint,
+		screenlogic.BodyOfWaterSpa:  options.MaxSpaSetPoint,
+	}
+
+	client.OnStatusRefresh(client.trackCircuitsOn)
 
 	err := client.connectToGateway()
 	if err != nil {
@@ -50,11 +120,32 @@ func NewConnectedClient(clientName string) (*Client, error) {
 }
 
 func (c *Client) connectToGateway() error {
-	gateway, err := screenlogic.DiscoverGateway()
+	var gateway *screenlogic.Gateway
+	var err error
+
+	switch {
+	case c.remoteName != "":
+		gateway, err = screenlogic.LookupRemoteGateway(c.dispatcher, c.remoteName)
+	case c.gatewayAddress != "":
+		gateway, err = screenlogic.NewGateway(c.gatewayAddress)
+	default:
+		gateway, err = screenlogic.DiscoverGateway()
+	}
 	if err != nil {
 		return err
 	}
 
+	gateway.Password = c.password
+	gateway.ReadOnly = c.readOnly
+
+	// This is called in the middle of whatever request the gateway sent it with, which might be a
+	// keepalive ping that doesn't hold requestMutex.
+	gateway.OnWeatherForecastChanged(func() {
+		c.cache.weatherForecast.mutex.Lock()
+		c.cache.weatherForecast.deadline = 0
+		c.cache.weatherForecast.mutex.Unlock()
+	})
+
 	err = gateway.Connect()
 	if err != nil {
 		return err
@@ -67,16 +158,26 @@ func (c *Client) connectToGateway() error {
 
 	c.gateway = gateway
 
+	if c.keepalive > 0 {
+		gateway.StartKeepalive(c.keepalive)
+	}
+
 	return nil
 }
 
+// OnStatusRefresh - registers fn to be called every time the pool status is refreshed from the gateway.
+// This should be called before the client is shared with anything else.
+func (c *Client) OnStatusRefresh(fn StatusListener) {
+	c.statusListeners = append(c.statusListeners, fn)
+}
+
 func (c *Client) GetAirTemperature() (float64, error) {
 	status, err := c.getPoolStatus()
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	return c.convertTempToHomeKit(status.AirTemp), nil
+	return c.convertTempToHomeKit(status.AirTemp)
 }
 
 func (c *Client) GetGatewayName() string {
@@ -84,211 +185,666 @@ func (c *Client) GetGatewayName() string {
 }
 
 func (c *Client) GetGatewayVersion() (string, error) {
-	version, err := c.gateway.Version()
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+
+	var version string
+
+	err := c.withReconnect("version", func() error {
+		var err error
+
+		version, err = c.gateway.Version()
+
+		return err
+	})
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
 	return version, nil
 }
 
-func (c *Client) GetTemperatureDisplayUnits() int {
+// GetConnectionHealth - doesn't wait on other requests, so it can be checked even when the gateway
+// is slow to respond.
+func (c *Client) GetConnectionHealth() screenlogic.ConnectionHealth {
+	return c.gateway.Health()
+}
+
+func (c *Client) GetGatewayMacAddr() string {
+	return c.gateway.MacAddr
+}
+
+func (c *Client) GetTemperatureDisplayUnits() (int, error) {
 	config, err := c.getControllerConfig()
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
 	if config.IsCelcius {
-		return characteristic.TemperatureDisplayUnitsCelsius
+		return characteristic.TemperatureDisplayUnitsCelsius, nil
 	}
 
-	return characteristic.TemperatureDisplayUnitsFahrenheit
+	return characteristic.TemperatureDisplayUnitsFahrenheit, nil
 }
 
-func (c *Client) GetCurrentPoolTemp() float64 {
-	status, err := c.getPoolStatus()
+func (c *Client) GetCurrentPoolTemp() (float64, error) {
+	info, err := c.getBodyStatus(screenlogic.BodyOfWaterPool)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	info := status.PoolWater()
-
 	return c.convertTempToHomeKit(info.CurrentTemp)
 }
 
-func (c *Client) GetCurrentSpaTemp() float64 {
-	status, err := c.getPoolStatus()
+func (c *Client) GetCurrentSpaTemp() (float64, error) {
+	info, err := c.getBodyStatus(screenlogic.BodyOfWaterSpa)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	info := status.SpaWater()
-
 	return c.convertTempToHomeKit(info.CurrentTemp)
 }
 
-func (c *Client) GetPoolHeaterActive() int {
-	status, err := c.getPoolStatus()
+func (c *Client) GetPoolHeaterActive() (int, error) {
+	info, err := c.getBodyStatus(screenlogic.BodyOfWaterPool)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	info := status.PoolWater()
-
 	if screenlogic.HeatMode(info.HeatMode) == screenlogic.HeatModeOn {
-		return characteristic.ActiveActive
+		return characteristic.ActiveActive, nil
 	}
 
-	return characteristic.ActiveInactive
+	return characteristic.ActiveInactive, nil
 }
 
-func (c *Client) GetSpaHeaterActive() int {
-	status, err := c.getPoolStatus()
+func (c *Client) GetSpaHeaterActive() (int, error) {
+	info, err := c.getBodyStatus(screenlogic.BodyOfWaterSpa)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	info := status.SpaWater()
-
 	if screenlogic.HeatMode(info.HeatMode) == screenlogic.HeatModeOn {
-		return characteristic.ActiveActive
+		return characteristic.ActiveActive, nil
 	}
 
-	return characteristic.ActiveInactive
+	return characteristic.ActiveInactive, nil
 }
 
-func (c *Client) GetPoolCurrentHeatingState() int {
-	status, err := c.getPoolStatus()
+func (c *Client) GetPoolCurrentHeatingState() (int, error) {
+	info, err := c.getBodyStatus(screenlogic.BodyOfWaterPool)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	info := status.PoolWater()
+	ready, err := c.controllerReady()
+	if err != nil {
+		return 0, err
+	}
 
-	if info.HeaterStatus == 1 {
-		return characteristic.CurrentHeaterCoolerStateHeating
+	// In service mode the controller isn't running anything, whatever the last heater status was.
+	if info.HeaterStatus == 1 && ready {
+		return characteristic.CurrentHeaterCoolerStateHeating, nil
 	}
 
-	return characteristic.CurrentHeaterCoolerStateInactive
+	return characteristic.CurrentHeaterCoolerStateInactive, nil
 }
 
-func (c *Client) GetSpaCurrentHeatingState() int {
-	status, err := c.getPoolStatus()
+func (c *Client) GetSpaCurrentHeatingState() (int, error) {
+	info, err := c.getBodyStatus(screenlogic.BodyOfWaterSpa)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	info := status.SpaWater()
+	ready, err := c.controllerReady()
+	if err != nil {
+		return 0, err
+	}
 
-	if info.HeaterStatus == 1 {
-		return characteristic.CurrentHeaterCoolerStateHeating
+	// In service mode the controller isn't running anything, whatever the last heater status was.
+	if info.HeaterStatus == 1 && ready {
+		return characteristic.CurrentHeaterCoolerStateHeating, nil
 	}
 
-	return characteristic.CurrentHeaterCoolerStateInactive
+	return characteristic.CurrentHeaterCoolerStateInactive, nil
 }
 
-func (c *Client) GetPoolTargetHeatingState() int {
-	status, err := c.getPoolStatus()
+func (c *Client) GetPoolTargetHeatingState() (int, error) {
+	info, err := c.getBodyStatus(screenlogic.BodyOfWaterPool)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	info := status.PoolWater()
-
 	switch screenlogic.HeatMode(info.HeatMode) {
 	case screenlogic.HeatModeOff:
-		return characteristic.TargetHeaterCoolerStateAuto
+		return characteristic.TargetHeaterCoolerStateAuto, nil
 	case screenlogic.HeatModeOn:
-		return characteristic.TargetHeaterCoolerStateHeat
+		return characteristic.TargetHeaterCoolerStateHeat, nil
 	case screenlogic.HeatModeSolarOnly:
-		return characteristic.TargetHeaterCoolerStateHeat
+		return characteristic.TargetHeaterCoolerStateHeat, nil
 	case screenlogic.HeatModeSolarPreferred:
-		return characteristic.TargetHeaterCoolerStateHeat
+		return characteristic.TargetHeaterCoolerStateHeat, nil
 	default:
-		return characteristic.TargetHeaterCoolerStateAuto
+		return characteristic.TargetHeaterCoolerStateAuto, nil
 	}
 }
 
-func (c *Client) GetSpaTargetHeatingState() int {
-	status, err := c.getPoolStatus()
+func (c *Client) GetSpaTargetHeatingState() (int, error) {
+	info, err := c.getBodyStatus(screenlogic.BodyOfWaterSpa)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	info := status.SpaWater()
-
 	switch screenlogic.HeatMode(info.HeatMode) {
 	case screenlogic.HeatModeOff:
-		return characteristic.TargetHeaterCoolerStateAuto
+		return characteristic.TargetHeaterCoolerStateAuto, nil
 	case screenlogic.HeatModeOn:
-		return characteristic.TargetHeaterCoolerStateHeat
+		return characteristic.TargetHeaterCoolerStateHeat, nil
 	case screenlogic.HeatModeSolarOnly:
-		return characteristic.TargetHeaterCoolerStateHeat
+		return characteristic.TargetHeaterCoolerStateHeat, nil
 	case screenlogic.HeatModeSolarPreferred:
-		return characteristic.TargetHeaterCoolerStateHeat
+		return characteristic.TargetHeaterCoolerStateHeat, nil
 	default:
-		return characteristic.TargetHeaterCoolerStateAuto
+		return characteristic.TargetHeaterCoolerStateAuto, nil
 	}
 }
 
-func (c *Client) GetPoolHeatingThresholdTemp() float64 {
-	status, err := c.getPoolStatus()
+func (c *Client) GetPoolHeatingThresholdTemp() (float64, error) {
+	info, err := c.getBodyStatus(screenlogic.BodyOfWaterPool)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	info := status.PoolWater()
+	return c.convertTempToHomeKit(info.HeatSetPoint)
+}
+
+func (c *Client) GetSpaHeatingThresholdTemp() (float64, error) {
+	info, err := c.getBodyStatus(screenlogic.BodyOfWaterSpa)
+	if err != nil {
+		return 0, err
+	}
 
 	return c.convertTempToHomeKit(info.HeatSetPoint)
 }
 
-func (c *Client) GetSpaHeatingThresholdTemp() float64 {
+func (c *Client) SetTemperature(body screenlogic.BodyOfWater, temperature uint32) error {
+	err := c.checkBody(body)
+	if err != nil {
+		return err
+	}
+
+	max := c.maxSetPoints[body]
+	if max > 0 && temperature > max {
+		return fmt.Errorf("%w: %d is above this site's limit of %d", screenlogic.SetPointOutOfRangeErr, temperature, max)
+	}
+
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+
+	err = c.withReconnect("set_temperature", func() error {
+		return c.gateway.SetTemperature(0, body, temperature)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.expirePoolStatus()
+
+	return nil
+}
+
+func (c *Client) SetHeatMode(body screenlogic.BodyOfWater, mode screenlogic.HeatMode) error {
+	err := c.checkBody(body)
+	if err != nil {
+		return err
+	}
+
+	if mode == screenlogic.HeatModeSolarOnly || mode == screenlogic.HeatModeSolarPreferred {
+		config, err := c.getControllerConfig()
+		if err != nil {
+			return err
+		}
+
+		if !config.HasSolar() {
+			return fmt.Errorf("%w, heat mode %s needs it", screenlogic.NoSolarErr, mode)
+		}
+	}
+
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+
+	err = c.withReconnect("set_heat_mode", func() error {
+		return c.gateway.SetHeatMode(0, body, mode)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.expirePoolStatus()
+
+	return nil
+}
+
+func (c *Client) SetCircuitState(circuitID uint32, on bool) error {
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+
+	err := c.withReconnect("set_circuit_state", func() error {
+		return c.gateway.SetCircuitState(0, circuitID, on)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The controller starts the egg timer now, which is closer than the next status would tell us. A circuit
+	// that was already on keeps whatever start we had for it.
+	if _, ok := c.circuitOnSince[circuitID]; on && !ok {
+		c.circuitOnSince[circuitID] = time.Now()
+	}
+
+	c.expirePoolStatus()
+
+	return nil
+}
+
+// GetStatusFault - a fault while the controller isn't ready, or when we can't reach the gateway to find out.
+func (c *Client) GetStatusFault() int {
+	ready, err := c.controllerReady()
+	if err != nil || !ready {
+		return characteristic.StatusFaultGeneralFault
+	}
+
+	return characteristic.StatusFaultNoFault
+}
+
+func (c *Client) GetFreezeProtectionDetected() (int, error) {
 	status, err := c.getPoolStatus()
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 
-	info := status.SpaWater()
+	if status.FreezeMode != 0 {
+		return characteristic.OccupancyDetectedOccupancyDetected, nil
+	}
 
-	return c.convertTempToHomeKit(info.HeatSetPoint)
+	return characteristic.OccupancyDetectedOccupancyNotDetected, nil
 }
 
-func (c *Client) getControllerConfig() (*screenlogic.ControllerConfiguration, error) {
+func (c *Client) GetServiceModeDetected() (int, error) {
+	status, err := c.getPoolStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	if status.IsInServiceMode() {
+		return characteristic.OccupancyDetectedOccupancyDetected, nil
+	}
+
+	return characteristic.OccupancyDetectedOccupancyNotDetected, nil
+}
+
+// GetChemistryAlarmDetected - whether the IntelliChem is reporting any alarm.
+func (c *Client) GetChemistryAlarmDetected() (int, error) {
+	status, err := c.getPoolStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	if status.Chemistry.Alarms != 0 {
+		return characteristic.OccupancyDetectedOccupancyDetected, nil
+	}
+
+	return characteristic.OccupancyDetectedOccupancyNotDetected, nil
+}
+
+// GetLowSaltDetected - whether the chlorinator's salt level is below minPPM.
+func (c *Client) GetLowSaltDetected(minPPM uint32) (int, error) {
+	status, err := c.getPoolStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	if status.Chemistry.SaltPPM < minPPM {
+		return characteristic.OccupancyDetectedOccupancyDetected, nil
+	}
+
+	return characteristic.OccupancyDetectedOccupancyNotDetected, nil
+}
+
+func (c *Client) GetPoolStatusActive() (bool, error) {
+	status, err := c.getPoolStatus()
+	if err != nil {
+		return false, err
+	}
+
+	return status.PoolDelay == 0, nil
+}
+
+func (c *Client) GetSpaStatusActive() (bool, error) {
+	status, err := c.getPoolStatus()
+	if err != nil {
+		return false, err
+	}
+
+	return status.SpaDelay == 0, nil
+}
+
+func (c *Client) GetDelayActive() (bool, error) {
+	status, err := c.getPoolStatus()
+	if err != nil {
+		return false, err
+	}
+
+	return status.HasDelay(), nil
+}
+
+func (c *Client) CancelDelay() error {
 	c.requestMutex.Lock()
 	defer c.requestMutex.Unlock()
 
-	if c.cache.controllerConfig.last != nil && time.Now().UnixNano() < c.cache.controllerConfig.deadline {
-		return c.cache.controllerConfig.last, nil
+	err := c.withReconnect("cancel_delay", func() error {
+		return c.gateway.CancelDelay()
+	})
+	if err != nil {
+		return err
 	}
 
-	retriesLeft := c.reconnectRetries
+	c.expirePoolStatus()
 
-	var err error
+	return nil
+}
 
-retry:
-	c.cache.controllerConfig.last, err = c.gateway.ControllerConfig()
+// controllerReady - false while the controller is syncing or in service mode, when nothing it
+// reports can be trusted.
+func (c *Client) controllerReady() (bool, error) {
+	status, err := c.getPoolStatus()
 	if err != nil {
-		_, ok := err.(net.Error)
+		return false, err
+	}
 
-		if (ok || err == io.EOF) && retriesLeft > 0 {
-			retriesLeft--
+	return status.IsReady(), nil
+}
 
-			// connection most likely dropped, let's reconnect
-			log.Debug.Printf("getControllerConfig() - reconnect client attemp %d\n", c.reconnectRetries-retriesLeft)
+// SetCustomName - changes entry idx of the custom name table, renaming every circuit that uses it.
+func (c *Client) SetCustomName(idx uint32, name string) error {
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
 
-			err = c.gateway.Reconnect()
-			if err != nil {
-				// if we still get an error here, give up
-				panic(err)
-			}
+	err := c.withReconnect("set_custom_name", func() error {
+		return c.gateway.SetCustomName(idx, name)
+	})
+	if err != nil {
+		return err
+	}
 
-			goto retry
+	c.cache.controllerConfig.deadline = 0
+
+	return nil
+}
+
+func (c *Client) GetCircuitState(circuitID uint32) (bool, error) {
+	status, err := c.getPoolStatus()
+	if err != nil {
+		return false, err
+	}
+
+	for _, circuit := range status.Circuits {
+		if circuit.ID == circuitID {
+			return circuit.ValveState != 0, nil
 		}
+	}
 
-		// some other error happened, give up
+	return false, nil
+}
+
+// GetCircuitRuntime - the circuit's egg timer, how long it stays on for before the controller turns it off.
+func (c *Client) GetCircuitRuntime(circuitID uint32) (time.Duration, error) {
+	config, err := c.getControllerConfig()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, circuit := range config.Circuits {
+		if circuit.ID == circuitID {
+			return time.Duration(circuit.DefaultRT) * time.Minute, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// GetCircuitRemaining - how long is left before the controller turns the circuit off. The controller doesn't
+// report this, or when the circuit came on, so it's counted from when we turned it on, or otherwise from when
+// we first saw it on, which can be late by up to the cache expiry. It's 0 for a circuit that was already on
+// when we started, since there's no telling how long it's been on for.
+func (c *Client) GetCircuitRemaining(circuitID uint32) (time.Duration, error) {
+	runtime, err := c.GetCircuitRuntime(circuitID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Refreshes the status, and with it circuitOnSince.
+	_, err = c.getPoolStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	c.requestMutex.Lock()
+	since, on := c.circuitOnSince[circuitID]
+	c.requestMutex.Unlock()
+
+	if !on || since.IsZero() {
+		return 0, nil
+	}
+
+	remaining := runtime - time.Since(since)
+	if remaining < 0 {
+		return 0, nil
+	}
+
+	return remaining, nil
+}
+
+func (c *Client) SetCircuitRuntime(circuitID uint32, runtime time.Duration) error {
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+
+	err := c.withReconnect("set_circuit_runtime", func() error {
+		return c.gateway.SetCircuitRuntime(circuitID, runtime)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.cache.controllerConfig.deadline = 0
+
+	return nil
+}
+
+// trackCircuitsOn - keeps circuitOnSince up to date. Circuits that are already on in the first status we
+// see get a zero time, since they could have come on at any point before we started.
+func (c *Client) trackCircuitsOn(previous, current *screenlogic.PoolStatus) {
+	now := time.Now()
+	if previous == nil {
+		now = time.Time{}
+	}
+
+	for _, circuit := range current.Circuits {
+		if circuit.ValveState == 0 {
+			delete(c.circuitOnSince, circuit.ID)
+			continue
+		}
+
+		_, ok := c.circuitOnSince[circuit.ID]
+		if !ok {
+			c.circuitOnSince[circuit.ID] = now
+		}
+	}
+}
+
+// SyncSystemTime - sets the controller's clock from ours if it's off by more than tolerance.
+// Returns how far off it was.
+func (c *Client) SyncSystemTime(adjustForDST bool, tolerance time.Duration) (time.Duration, error) {
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+
+	var drift time.Duration
+
+	err := c.withReconnect("sync_system_time", func() error {
+		controllerTime, controllerAdjustForDST, err := c.gateway.SystemTime()
+		if err != nil {
+			return err
+		}
+
+		drift = controllerTime.Sub(time.Now())
+
+		if drift > -tolerance && drift < tolerance && controllerAdjustForDST == adjustForDST {
+			return nil
+		}
+
+		return c.gateway.SetSystemTime(time.Now(), adjustForDST)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return drift, nil
+}
+
+// GetWeatherForecast - cached like everything else, but refetched as soon as the gateway says it has
+// a new forecast.
+func (c *Client) GetWeatherForecast() (*screenlogic.WeatherForecast, error) {
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+
+	c.cache.weatherForecast.mutex.Lock()
+	deadline := c.cache.weatherForecast.deadline
+	c.cache.weatherForecast.mutex.Unlock()
+
+	if c.cache.weatherForecast.last != nil && time.Now().UnixNano() < deadline {
+		return c.cache.weatherForecast.last, nil
+	}
+
+	err := c.withReconnect("weather_forecast", func() error {
+		var err error
+
+		c.cache.weatherForecast.last, err = c.gateway.WeatherForecast()
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache.weatherForecast.mutex.Lock()
+	c.cache.weatherForecast.deadline = time.Now().Add(c.cache.defaultExpiry).UnixNano()
+	c.cache.weatherForecast.mutex.Unlock()
+
+	return c.cache.weatherForecast.last, nil
+}
+
+// GetHistory - history isn't cached, every call goes to the gateway.
+func (c *Client) GetHistory(start, end time.Time) (*protocol.HistoryDataResponsePacket, error) {
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+
+	var history *protocol.HistoryDataResponsePacket
+
+	err := c.withReconnect("history", func() error {
+		var err error
+
+		history, err = c.gateway.HistoryRange(start, end, screenlogic.DefaultHistoryChunk)
+
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
+	return history, nil
+}
+
+// checkBody - returns an error if the controller doesn't have body, so we don't send it
+// commands for equipment that isn't there.
+func (c *Client) checkBody(body screenlogic.BodyOfWater) error {
+	status, err := c.getPoolStatus()
+	if err != nil {
+		return err
+	}
+
+	if int(body) >= len(status.Bodies) {
+		return fmt.Errorf("controller has no body of water %d", body)
+	}
+
+	return nil
+}
+
+// getBodyStatus - the current status of body.
+func (c *Client) getBodyStatus(body screenlogic.BodyOfWater) (*protocol.BodyOfWater, error) {
+	status, err := c.getPoolStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var info *protocol.BodyOfWater
+
+	switch body {
+	case screenlogic.BodyOfWaterPool:
+		info = status.PoolWater()
+	case screenlogic.BodyOfWaterSpa:
+		info = status.SpaWater()
+	}
+
+	if info == nil {
+		return nil, fmt.Errorf("controller has no body of water %d", body)
+	}
+
+	return info, nil
+}
+
+// expirePoolStatus - forces the next getPoolStatus() call to go to the gateway, so changes
+// we just made show up right away.
+//
+// c.requestMutex must be held by the caller.
+func (c *Client) expirePoolStatus() {
+	c.cache.poolStatus.deadline = 0
+}
+
+func (c *Client) getControllerConfig() (*screenlogic.ControllerConfiguration, error) {
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+
+	if c.cache.controllerConfig.last != nil && time.Now().UnixNano() < c.cache.controllerConfig.deadline {
+		return c.cache.controllerConfig.last, nil
+	}
+
+	err := c.withReconnect("controller_config", func() error {
+		var err error
+
+		c.cache.controllerConfig.last, err = c.gateway.ControllerConfig()
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var names screenlogic.CustomNames
+
+	err = c.withReconnect("custom_names", func() error {
+		var err error
+
+		names, err = c.gateway.CustomNames()
+
+		return err
+	})
+	if err != nil {
+		// The built-in names are still better than nothing.
+		log.Info.Printf("unable to fetch custom circuit names: %v\n", err)
+	}
+
+	c.cache.controllerConfig.last.ResolveNames(names)
+
 	c.cache.controllerConfig.deadline = time.Now().Add(c.cache.defaultExpiry).UnixNano()
 
 	return c.cache.controllerConfig.last, nil
@@ -302,58 +858,184 @@ func (c *Client) getPoolStatus() (*screenlogic.PoolStatus, error) {
 		return c.cache.poolStatus.last, nil
 	}
 
-	retriesLeft := c.reconnectRetries
+	previous := c.cache.poolStatus.last
 
-	var err error
+	err := c.withReconnect("pool_status", func() error {
+		var err error
 
-retry:
-	c.cache.poolStatus.last, err = c.gateway.PoolStatus()
+		c.cache.poolStatus.last, err = c.gateway.PoolStatus()
+
+		return err
+	})
 	if err != nil {
-		_, ok := err.(net.Error)
+		return nil, err
+	}
 
-		if (ok || err == io.EOF) && retriesLeft > 0 {
+	c.cache.poolStatus.deadline = time.Now().Add(c.cache.defaultExpiry).UnixNano()
+
+	for _, listener := range c.statusListeners {
+		listener(previous, c.cache.poolStatus.last)
+	}
+
+	return c.cache.poolStatus.last, nil
+}
+
+// withReconnect - runs fn, which should make a single request to the gateway. If the request fails
+// because the connection dropped, we reconnect and try again up to c.reconnectRetries times.
+// Every attempt is recorded in c.metrics under op.
+//
+// c.requestMutex must be held by the caller.
+func (c *Client) withReconnect(op string, fn func() error) error {
+	retriesLeft := c.reconnectRetries
+
+retry:
+	start := time.Now()
+	err := fn()
+	c.metrics.observeRequest(op, time.Since(start), err)
+
+	if err != nil {
+		if isConnectionError(err) && retriesLeft > 0 {
 			retriesLeft--
 
 			// connection most likely dropped, let's reconnect
-			log.Debug.Printf("getPoolStatus() - reconnect client attemp %d\n", c.reconnectRetries-retriesLeft)
+			log.Debug.Printf("%s - reconnect client attemp %d\n", op, c.reconnectRetries-retriesLeft)
+
+			c.metrics.observeReconnect()
 
 			err = c.gateway.Reconnect()
 			if err != nil {
-				// if we still get an error here, give up
-				panic(err)
+				// if we still get an error here, give up until the next request
+				return fmt.Errorf("%s: reconnecting: %w", op, err)
 			}
 
 			goto retry
 		}
 
 		// some other error happened, give up
-		return nil, err
+		return err
 	}
 
-	c.cache.poolStatus.deadline = time.Now().Add(c.cache.defaultExpiry).UnixNano()
+	return nil
+}
 
-	return c.cache.poolStatus.last, nil
+// isConnectionError - whether err means the connection to the gateway dropped or couldn't be made, as
+// opposed to the gateway answering with an error.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF)
+}
+
+// getSetPointRange - the set points allowed for body, in the controller's units. This is the controller's
+// own range, capped by any site specific maximum.
+func (c *Client) getSetPointRange(body screenlogic.BodyOfWater) (uint32, uint32, error) {
+	config, err := c.getControllerConfig()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	allowed := config.AllowedPoolSetPointRange
+	if body == screenlogic.BodyOfWaterSpa {
+		allowed = config.AllowedSpaSetPointRange
+	}
+
+	min := uint32(allowed.Min)
+	max := uint32(allowed.Max)
+
+	siteMax := c.maxSetPoints[body]
+	if siteMax > 0 && siteMax < max {
+		max = siteMax
+	}
+
+	if max < min {
+		max = min
+	}
+
+	return min, max, nil
+}
+
+// setHomeKitSetPoint - sets body's set point to celsius, as it came from HomeKit. It's clamped to the allowed
+// range, since the ends of the slider can round to just outside of it.
+func (c *Client) setHomeKitSetPoint(body screenlogic.BodyOfWater, celsius float64) error {
+	min, max, err := c.getSetPointRange(body)
+	if err != nil {
+		return err
+	}
+
+	temperature, err := c.convertTempFromHomeKit(celsius)
+	if err != nil {
+		return err
+	}
+
+	return c.SetTemperature(body, clampSetPoint(temperature, min, max))
+}
+
+// getHomeKitSetPoint - body's set point in celsius, like GetPoolHeatingThresholdTemp but for either body.
+func (c *Client) getHomeKitSetPoint(body screenlogic.BodyOfWater) (float64, error) {
+	info, err := c.getBodyStatus(body)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.convertTempToHomeKit(info.HeatSetPoint)
 }
 
 func (c *Client) celsiusToFahrenheit(celsius uint32) float64 {
 	return float64(celsius*9/5 + 32)
 }
 
+// fahrenheitToCelsius - isn't rounded, so that converting back with convertTempFromHomeKit gives the
+// same temperature. That matters at the ends of the set point range.
 func (c *Client) fahrenheitToCelsius(fahrenheit uint32) float64 {
-	return float64((fahrenheit - 32) * 5 / 9)
+	return (float64(fahrenheit) - 32) * 5 / 9
 }
 
 // HomeKit always wants values to be in celsius, but the pool controller may be configured
 // for Fahrenheit. This should always give us what we want.
-func (c *Client) convertTempToHomeKit(poolTemp uint32) float64 {
-	units := c.GetTemperatureDisplayUnits()
+func (c *Client) convertTempToHomeKit(poolTemp uint32) (float64, error) {
+	units, err := c.GetTemperatureDisplayUnits()
+	if err != nil {
+		return 0, err
+	}
 
 	switch units {
 	case characteristic.TemperatureDisplayUnitsCelsius:
-		return float64(poolTemp)
+		return float64(poolTemp), nil
 	case characteristic.TemperatureDisplayUnitsFahrenheit:
-		return c.fahrenheitToCelsius(poolTemp)
+		return c.fahrenheitToCelsius(poolTemp), nil
 	default:
-		return c.fahrenheitToCelsius(poolTemp)
+		return c.fahrenheitToCelsius(poolTemp), nil
+	}
+}
+
+// convertTempFromHomeKit - the inverse of convertTempToHomeKit, rounded to the nearest degree.
+func (c *Client) convertTempFromHomeKit(celsius float64) (uint32, error) {
+	units, err := c.GetTemperatureDisplayUnits()
+	if err != nil {
+		return 0, err
+	}
+
+	return temperatureFromHomeKit(celsius, units), nil
+}
+
+func temperatureFromHomeKit(celsius float64, units int) uint32 {
+	if units == characteristic.TemperatureDisplayUnitsCelsius {
+		return uint32(math.Round(celsius))
+	}
+
+	return uint32(math.Round(celsius*9/5 + 32))
+}
+
+// clampSetPoint - keeps temperature within min and max, so a set point from the very end of a slider
+// isn't rejected because of rounding.
+func clampSetPoint(temperature, min, max uint32) uint32 {
+	if temperature < min {
+		return min
 	}
+
+	if temperature > max {
+		return max
+	}
+
+	return temperature
 }
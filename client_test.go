@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+	"github.com/brutella/hc/characteristic"
+)
+
+func weatherForecastBody() []byte {
+	return encodeBody(func(e *protocol.Encoder) {
+		e.WriteUint32(1)       // version
+		e.WriteString("12345") // zip code
+		e.WriteDateTime(time.Date(2026, 7, 4, 9, 0, 0, 0, time.Local))
+		e.WriteDateTime(time.Date(2026, 7, 4, 9, 5, 0, 0, time.Local))
+		e.WriteString("Sat, Jul 4")
+		e.WriteString("Sunny")
+		e.WriteUint32(85) // current temperature
+		e.WriteUint32(40) // humidity
+		e.WriteString("SW 10")
+		e.WriteUint32(30) // pressure
+		e.WriteUint32(55) // dew point
+		e.WriteUint32(85) // wind chill
+		e.WriteUint32(10) // visibility
+		e.WriteUint32(0)  // days
+		e.WriteUint32(6 * 60)
+		e.WriteUint32(20 * 60)
+	})
+}
+
+// The gateway can say the forecast changed in the middle of a keepalive ping, which doesn't go through
+// the Client. Run with -race.
+func TestWeatherForecastChangedDuringPing(t *testing.T) {
+	fg := newFakeGateway(t)
+
+	fg.reply(protocol.WeatherForecastPacketCode, weatherForecastBody())
+	fg.handle(protocol.PingPacketCode, func(req fakePacket) []fakePacket {
+		return []fakePacket{
+			{TypeCode: protocol.WeatherForcastChangedCode},
+			{TypeCode: protocol.PingResponsePacketCode},
+		}
+	})
+
+	client := fg.newClient(ClientOptions{})
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 50; i++ {
+			_, err := client.gateway.Ping()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		forecast, err := client.GetWeatherForecast()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if forecast.Text != "Sunny" {
+			t.Fatalf("forecast text is %q, want %q", forecast.Text, "Sunny")
+		}
+	}
+
+	wg.Wait()
+
+	fetched := len(fg.received(protocol.WeatherForecastPacketCode))
+
+	_, err := client.GetWeatherForecast()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fg.received(protocol.WeatherForecastPacketCode)) != fetched+1 {
+		t.Error("forecast was never fetched again after the gateway said it changed")
+	}
+}
+
+// A gateway that can't be reached again has to come back as an error, since the getters are called from
+// goroutines nothing would recover a panic in.
+func TestReconnectFailure(t *testing.T) {
+	fg := newFakePool(t)
+	client := fg.newClient(ClientOptions{ReconnectRetries: 2, CacheExpiry: time.Nanosecond})
+
+	fg.shutdown()
+
+	_, err := client.GetPoolStatusActive()
+	if err == nil {
+		t.Fatal("no error with the gateway gone")
+	}
+
+	if client.GetStatusFault() != characteristic.StatusFaultGeneralFault {
+		t.Error("no status fault with the gateway gone")
+	}
+}
+
+// The set point range is checked against the configuration the gateway already sent, not fetched again for
+// every write.
+func TestSetTemperatureUsesKnownRange(t *testing.T) {
+	fg := newFakePool(t)
+	client := fg.newClient(ClientOptions{})
+
+	_, err := client.getControllerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched := len(fg.received(protocol.ControllerConfigurationPacketCode))
+
+	for _, temperature := range []uint32{84, 85} {
+		err = client.SetTemperature(0, temperature)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := len(fg.received(protocol.ControllerConfigurationPacketCode)); got != fetched {
+		t.Errorf("configuration fetched %d more times for set points", got-fetched)
+	}
+
+	err = client.SetTemperature(0, 105)
+	if !errors.Is(err, screenlogic.SetPointOutOfRangeErr) {
+		t.Errorf("got %v for a set point above the range, want %v", err, screenlogic.SetPointOutOfRangeErr)
+	}
+}
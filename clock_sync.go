@@ -0,0 +1,36 @@
+package main
+
+import (
+	"time"
+
+	"github.com/brutella/hc/log"
+)
+
+// The controller only keeps time to the second, and reading it takes a round trip, so
+// don't bother correcting anything smaller than this.
+const clockSyncTolerance = 5 * time.Second
+
+// clockSyncLoop - keeps the controller's clock in sync with ours, so schedules run when they're
+// meant to, even after the controller loses power. Runs forever.
+func clockSyncLoop(client *Client, interval time.Duration, adjustForDST bool) {
+	syncClock := func() {
+		drift, err := client.SyncSystemTime(adjustForDST, clockSyncTolerance)
+		if err != nil {
+			log.Info.Printf("clock sync: %v\n", err)
+			return
+		}
+
+		if drift <= -clockSyncTolerance || drift >= clockSyncTolerance {
+			log.Info.Printf("clock sync: controller clock was off by %s, corrected it\n", drift.Round(time.Second))
+		}
+	}
+
+	syncClock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		syncClock()
+	}
+}
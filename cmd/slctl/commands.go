@@ -0,0 +1,525 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+func runDiscover(args []string) error {
+	gateway, err := screenlogic.DiscoverGateway()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", gateway.Name)
+	fmt.Printf("  address: %s:%d\n", gateway.IP, gateway.Port)
+	fmt.Printf("  type:    %d\n", gateway.Type)
+	fmt.Printf("  subnet:  %d\n", gateway.Subnet)
+
+	return nil
+}
+
+func runStatus(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the raw status as JSON")
+	flags.Parse(args)
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	status, err := gateway.PoolStatus()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(status)
+	}
+
+	config, err := controllerConfig(gateway)
+	if err != nil {
+		return err
+	}
+
+	units := temperatureUnits(config)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Controller:\t%s\n", controllerState(status))
+	fmt.Fprintf(w, "Freeze mode:\t%s\n", onOff(status.FreezeMode != 0))
+	fmt.Fprintf(w, "Air:\t%d%s\n", status.AirTemp, units)
+
+	bodies := []struct {
+		name string
+		body *protocol.BodyOfWater
+	}{
+		{"Pool", status.PoolWater()},
+		{"Spa", status.SpaWater()},
+	}
+
+	for _, b := range bodies {
+		if b.body == nil {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s:\t%d%s (set point %d%s, heat mode %s, heater %s)\n",
+			b.name,
+			b.body.CurrentTemp, units,
+			b.body.HeatSetPoint, units,
+			screenlogic.HeatMode(b.body.HeatMode),
+			onOff(b.body.HeaterStatus != 0),
+		)
+	}
+
+	fmt.Fprintf(w, "Delays:\t%s\n", delays(status))
+
+	fmt.Fprintf(w, "Chemistry:\tpH %.2f, ORP %d mV, salt %d ppm, saturation %.2f\n",
+		status.Chemistry.PH,
+		status.Chemistry.ORP,
+		status.Chemistry.SaltPPM,
+		status.Chemistry.Saturation,
+	)
+
+	fmt.Fprintf(w, "Circuits:\t\n")
+
+	for _, circuit := range status.Circuits {
+		fmt.Fprintf(w, "  %s (%d)\t%s\n", circuitName(config, circuit.ID), circuit.ID, onOff(circuit.ValveState != 0))
+	}
+
+	return w.Flush()
+}
+
+func runConfig(args []string) error {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the raw configuration as JSON")
+	flags.Parse(args)
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	config, err := controllerConfig(gateway)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(config)
+	}
+
+	units := temperatureUnits(config)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Controller ID:\t%d\n", config.ControllerID)
+	fmt.Fprintf(w, "Controller type:\t%s (type %d, hardware %d)\n", config.Model(), config.ControllerType, config.HardwareType)
+	fmt.Fprintf(w, "Units:\t%s\n", units)
+	fmt.Fprintf(w, "Pool set point range:\t%d-%d%s\n", config.AllowedPoolSetPointRange.Min, config.AllowedPoolSetPointRange.Max, units)
+	fmt.Fprintf(w, "Spa set point range:\t%d-%d%s\n", config.AllowedSpaSetPointRange.Min, config.AllowedSpaSetPointRange.Max, units)
+	fmt.Fprintf(w, "Dual body:\t%t\n", config.IsDualBody())
+	fmt.Fprintf(w, "Solar:\t%t\n", config.HasSolar())
+	fmt.Fprintf(w, "Chlorinator:\t%t\n", config.HasChlorinator())
+	fmt.Fprintf(w, "IntelliChem:\t%t\n", config.HasIntellichem())
+	fmt.Fprintf(w, "Cooling:\t%t\n", config.HasCooling())
+	fmt.Fprintf(w, "Equipment:\t%s\n", config.EquipmentFlags)
+	fmt.Fprintf(w, "Circuits:\t\n")
+
+	for _, circuit := range config.Circuits {
+		fmt.Fprintf(w, "  %s\t%d (function %s, interface %s, flags %s, runtime %v)\n", circuit.Name, circuit.ID, circuit.Function, circuit.Interface, circuit.Flags, time.Duration(circuit.DefaultRT)*time.Minute)
+	}
+
+	return w.Flush()
+}
+
+func runVersion(args []string) error {
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	version, err := gateway.Version()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(version)
+
+	return nil
+}
+
+func runHistory(args []string) error {
+	now := time.Now()
+
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	from := flags.String("from", now.Add(-24*time.Hour).Format(time.RFC3339), "start of the range, as YYYY-MM-DD or RFC3339")
+	to := flags.String("to", now.Format(time.RFC3339), "end of the range, as YYYY-MM-DD or RFC3339")
+	format := flags.String("format", "json", "output format: json, jsonl or csv")
+	units := flags.String("units", "", "temperature units to export as jsonl or csv, C or F (defaults to the controller's units)")
+	chunk := flags.Duration("chunk", screenlogic.DefaultHistoryChunk, "longest range to request from the gateway at once")
+	flags.Parse(args)
+
+	// json is the raw decoded packet, whole degrees in the controller's units, so there's nothing to convert.
+	if *format == "json" && *units != "" {
+		return errors.New("--units only applies to the jsonl and csv formats")
+	}
+
+	start, err := parseTime(*from)
+	if err != nil {
+		return err
+	}
+
+	end, err := parseTime(*to)
+	if err != nil {
+		return err
+	}
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	history, err := gateway.HistoryRange(start, end, *chunk)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		return printJSON(history)
+	}
+
+	config, err := controllerConfig(gateway)
+	if err != nil {
+		return err
+	}
+
+	targetUnits := config.Units()
+
+	switch strings.ToUpper(*units) {
+	case "":
+	case "C":
+		targetUnits = screenlogic.Celsius
+	case "F":
+		targetUnits = screenlogic.Fahrenheit
+	default:
+		return fmt.Errorf("invalid units %q, expected C or F", *units)
+	}
+
+	records := screenlogic.HistoryRecords(history, config.Units(), targetUnits)
+
+	switch *format {
+	case "jsonl":
+		return screenlogic.WriteHistoryJSONLines(os.Stdout, records)
+	case "csv":
+		return screenlogic.WriteHistoryCSV(os.Stdout, records)
+	default:
+		return fmt.Errorf("invalid format %q, expected json, jsonl or csv", *format)
+	}
+}
+
+func runSetTemp(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected a body of water and a temperature")
+	}
+
+	body, err := parseBody(args[0])
+	if err != nil {
+		return err
+	}
+
+	temp, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid temperature %q", args[1])
+	}
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	return gateway.SetTemperature(0, body, uint32(temp))
+}
+
+func runHeatMode(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected a body of water and a heat mode")
+	}
+
+	body, err := parseBody(args[0])
+	if err != nil {
+		return err
+	}
+
+	mode, err := screenlogic.ParseHeatMode(args[1])
+	if err != nil {
+		return err
+	}
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	return gateway.SetHeatMode(0, body, mode)
+}
+
+func runCircuit(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected a circuit name or ID and on|off")
+	}
+
+	var on bool
+
+	switch strings.ToLower(args[1]) {
+	case "on":
+		on = true
+	case "off":
+		on = false
+	default:
+		return fmt.Errorf("invalid circuit state %q, expected on or off", args[1])
+	}
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	config, err := controllerConfig(gateway)
+	if err != nil {
+		return err
+	}
+
+	circuitID, err := findCircuit(config, args[0])
+	if err != nil {
+		return err
+	}
+
+	return gateway.SetCircuitState(0, circuitID, on)
+}
+
+func runRuntime(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: runtime NAME|ID DURATION")
+	}
+
+	runtime, err := time.ParseDuration(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected something like 45m or 2h", args[1])
+	}
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	config, err := controllerConfig(gateway)
+	if err != nil {
+		return err
+	}
+
+	circuitID, err := findCircuit(config, args[0])
+	if err != nil {
+		return err
+	}
+
+	return gateway.SetCircuitRuntime(circuitID, runtime)
+}
+
+func runCancelDelay(args []string) error {
+	if len(args) != 0 {
+		return errors.New("cancel-delay takes no arguments")
+	}
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	return gateway.CancelDelay()
+}
+
+func runTime(args []string) error {
+	flags := flag.NewFlagSet("time", flag.ExitOnError)
+	sync := flags.Bool("sync", false, "set the controller's clock from this machine's")
+	adjustForDST := flags.Bool("dst", true, "with --sync, have the controller adjust for daylight saving time")
+	flags.Parse(args)
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	if *sync {
+		err = gateway.SetSystemTime(time.Now(), *adjustForDST)
+		if err != nil {
+			return err
+		}
+	}
+
+	controllerTime, controllerAdjustForDST, err := gateway.SystemTime()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Controller time:\t%s\n", controllerTime.Format("2006-01-02 15:04:05 Mon"))
+	fmt.Fprintf(w, "Off by:\t%s\n", controllerTime.Sub(time.Now()).Round(time.Second))
+	fmt.Fprintf(w, "Adjust for DST:\t%s\n", onOff(controllerAdjustForDST))
+
+	return w.Flush()
+}
+
+func runWeather(args []string) error {
+	flags := flag.NewFlagSet("weather", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the raw forecast as JSON")
+	flags.Parse(args)
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	forecast, err := gateway.WeatherForecast()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(forecast)
+	}
+
+	config, err := controllerConfig(gateway)
+	if err != nil {
+		return err
+	}
+
+	units := temperatureUnits(config)
+	now := time.Now()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Zip code:\t%s\n", forecast.ZipCode)
+	fmt.Fprintf(w, "Updated:\t%s\n", forecast.LastUpdate.Format("2006-01-02 15:04"))
+	fmt.Fprintf(w, "Conditions:\t%s\n", forecast.Text)
+	fmt.Fprintf(w, "Temperature:\t%d%s (humidity %d%%, wind %s)\n", forecast.CurrentTemp, units, forecast.Humidity, forecast.Wind)
+	fmt.Fprintf(w, "Sunrise:\t%s\n", forecast.SunriseOn(now).Format("15:04"))
+	fmt.Fprintf(w, "Sunset:\t%s\n", forecast.SunsetOn(now).Format("15:04"))
+
+	for _, day := range forecast.Days {
+		fmt.Fprintf(w, "%s:\thigh %d%s, low %d%s\n", day.Date.Format("Mon Jan 2"), day.HighTemp, units, day.LowTemp, units)
+	}
+
+	return w.Flush()
+}
+
+func runEquipment(args []string) error {
+	flags := flag.NewFlagSet("equipment", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the raw equipment configuration as JSON")
+	flags.Parse(args)
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	equipment, err := gateway.EquipmentConfig()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(equipment)
+	}
+
+	config, err := controllerConfig(gateway)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Version:\t%s\n", equipment.Version())
+	fmt.Fprintf(w, "Expansions:\t%d\n", equipment.ExpansionCount())
+	fmt.Fprintf(w, "IntelliChem:\t%s\n", onOff(equipment.HasIntelliChem()))
+	fmt.Fprintf(w, "Manual heat:\t%s\n", onOff(equipment.HasManualHeat()))
+
+	delays := equipment.Delays()
+
+	fmt.Fprintf(w, "Pool pump on during heater cool-down:\t%s\n", onOff(delays.PoolPumpOnDuringHeaterCooldown))
+	fmt.Fprintf(w, "Spa pump on during heater cool-down:\t%s\n", onOff(delays.SpaPumpOnDuringHeaterCooldown))
+	fmt.Fprintf(w, "Pump off during valve action:\t%s\n", onOff(delays.PumpOffDuringValveAction))
+
+	for _, deviceID := range equipment.HighSpeedCircuits() {
+		fmt.Fprintf(w, "High speed:\t%s\n", deviceName(config, deviceID))
+	}
+
+	for _, valve := range equipment.Valves() {
+		fmt.Fprintf(w, "Valve %s:\t%s\n", valve.Name, deviceName(config, valve.DeviceID))
+	}
+
+	return w.Flush()
+}
+
+func runNames(args []string) error {
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	names, err := gateway.CustomNames()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	for idx, name := range names {
+		fmt.Fprintf(w, "%d:\t%s\n", idx, name)
+	}
+
+	return w.Flush()
+}
+
+func runSetName(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: set-name INDEX NAME")
+	}
+
+	idx, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid index %q", args[0])
+	}
+
+	gateway, err := connect()
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	return gateway.SetCustomName(uint32(idx), args[1])
+}
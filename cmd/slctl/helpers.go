@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
+)
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
+
+// controllerConfig - fetches the controller configuration, with circuit names resolved through the
+// custom name table.
+func controllerConfig(gateway *screenlogic.Gateway) (*screenlogic.ControllerConfiguration, error) {
+	config, err := gateway.ControllerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := gateway.CustomNames()
+	if err != nil {
+		return nil, err
+	}
+
+	config.ResolveNames(names)
+
+	return config, nil
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+
+	return "off"
+}
+
+func temperatureUnits(config *screenlogic.ControllerConfiguration) string {
+	return "°" + string(config.Units())
+}
+
+func controllerState(status *screenlogic.PoolStatus) string {
+	switch {
+	case status.IsReady():
+		return "ready"
+	case status.IsSync():
+		return "syncing"
+	case status.IsInServiceMode():
+		return "service mode"
+	default:
+		return fmt.Sprintf("unknown (%d)", status.OK)
+	}
+}
+
+func circuitName(config *screenlogic.ControllerConfiguration, circuitID uint32) string {
+	for _, circuit := range config.Circuits {
+		if circuit.ID == circuitID {
+			return circuit.Name
+		}
+	}
+
+	return "unknown"
+}
+
+// deviceName - looks up a circuit by the device ID the equipment configuration refers to it by.
+func deviceName(config *screenlogic.ControllerConfiguration, deviceID uint8) string {
+	for _, circuit := range config.Circuits {
+		if circuit.DeviceID == deviceID {
+			return circuit.Name
+		}
+	}
+
+	return fmt.Sprintf("device %d", deviceID)
+}
+
+// findCircuit - looks up a circuit by its name (case-insensitive), falling back to
+// treating the argument as a numeric circuit ID.
+func findCircuit(config *screenlogic.ControllerConfiguration, nameOrID string) (uint32, error) {
+	for _, circuit := range config.Circuits {
+		if strings.EqualFold(circuit.Name, nameOrID) {
+			return circuit.ID, nil
+		}
+	}
+
+	id, err := strconv.ParseUint(nameOrID, 10, 32)
+	if err == nil {
+		for _, circuit := range config.Circuits {
+			if circuit.ID == uint32(id) {
+				return circuit.ID, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unknown circuit %q", nameOrID)
+}
+
+func parseBody(name string) (screenlogic.BodyOfWater, error) {
+	switch strings.ToLower(name) {
+	case "pool":
+		return screenlogic.BodyOfWaterPool, nil
+	case "spa":
+		return screenlogic.BodyOfWaterSpa, nil
+	default:
+		return 0, fmt.Errorf("invalid body of water %q, expected pool or spa", name)
+	}
+}
+
+// parseTime - accepts either a plain date (interpreted in local time) or a full RFC3339 timestamp.
+func parseTime(value string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err == nil {
+		return t, nil
+	}
+
+	t, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected YYYY-MM-DD or RFC3339", value)
+	}
+
+	return t, nil
+}
+
+// delays - lists which delays are active, like "pool, cleaner".
+func delays(status *screenlogic.PoolStatus) string {
+	var active []string
+
+	if status.PoolDelay != 0 {
+		active = append(active, "pool")
+	}
+
+	if status.SpaDelay != 0 {
+		active = append(active, "spa")
+	}
+
+	if status.CleanerDelay != 0 {
+		active = append(active, "cleaner")
+	}
+
+	if len(active) == 0 {
+		return "none"
+	}
+
+	return strings.Join(active, ", ")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"discover", "discover", runDiscover},
+	{"status", "status [--json]", runStatus},
+	{"config", "config [--json]", runConfig},
+	{"equipment", "equipment [--json]", runEquipment},
+	{"names", "names", runNames},
+	{"set-name", "set-name INDEX NAME", runSetName},
+	{"version", "version", runVersion},
+	{"history", "history [--from DATE] [--to DATE] [--format json|jsonl|csv] [--units C|F] [--chunk DURATION]", runHistory},
+	{"set-temp", "set-temp pool|spa TEMP", runSetTemp},
+	{"heat-mode", "heat-mode pool|spa off|solar|solar-preferred|on", runHeatMode},
+	{"circuit", "circuit NAME|ID on|off", runCircuit},
+	{"runtime", "runtime NAME|ID DURATION", runRuntime},
+	{"cancel-delay", "cancel-delay", runCancelDelay},
+	{"time", "time [--sync] [--dst=false]", runTime},
+	{"weather", "weather [--json]", runWeather},
+}
+
+var clientName string
+var gatewayAddress string
+var remoteName string
+var dispatcherAddress string
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: slctl [flags] <command> [args]\n\nflags:\n")
+	flag.PrintDefaults()
+
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", cmd.usage)
+	}
+}
+
+func main() {
+	flag.StringVar(&clientName, "client-name", "slctl", "client name to log in to the gateway with")
+	flag.StringVar(&gatewayAddress, "gateway", os.Getenv("SCREENLOGIC_GATEWAY_ADDRESS"), "gateway address as host[:port] (discovered on the local network if empty)")
+	flag.StringVar(&remoteName, "remote", os.Getenv("SCREENLOGIC_GATEWAY_REMOTE_NAME"), "name of a gateway to connect to through the pentair dispatcher, e.g. \"Pentair: 01-23-45\"")
+	flag.StringVar(&dispatcherAddress, "dispatcher", screenlogic.DefaultDispatcherAddress, "dispatcher to look up -remote gateways with")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		err := cmd.run(flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "slctl %s: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "slctl: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// connect - discovers the gateway on the local network (unless -gateway was given), then connects
+// and logs in to it.
+func connect() (*screenlogic.Gateway, error) {
+	var gateway *screenlogic.Gateway
+	var err error
+
+	switch {
+	case remoteName != "":
+		gateway, err = screenlogic.LookupRemoteGateway(dispatcherAddress, remoteName)
+	case gatewayAddress != "":
+		gateway, err = screenlogic.NewGateway(gatewayAddress)
+	default:
+		gateway, err = screenlogic.DiscoverGateway()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	gateway.Password = os.Getenv("SCREENLOGIC_GATEWAY_PASSWORD")
+
+	err = gateway.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	err = gateway.Login(clientName)
+	if err != nil {
+		gateway.Close()
+		return nil, err
+	}
+
+	return gateway, nil
+}
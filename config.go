@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
+)
+
+// Duration - a time.Duration that is written as a string like "1m30s" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+
+	err := json.Unmarshal(data, &str)
+	if err != nil {
+		return err
+	}
+
+	d.Duration, err = time.ParseDuration(str)
+
+	return err
+}
+
+type GatewayConfig struct {
+	// Address is host[:port]. If empty, the gateway is discovered on the local network.
+	Address  string `json:"address"`
+	Password string `json:"password"`
+
+	// RemoteName connects through Pentair's dispatcher to the gateway with this name (like "Pentair: 01-23-45"),
+	// for when it's not on the local network. This needs the gateway's password.
+	RemoteName string `json:"remote_name"`
+	Dispatcher string `json:"dispatcher"`
+
+	ClientName       string   `json:"client_name"`
+	CacheExpiry      Duration `json:"cache_expiry"`
+	ReconnectRetries uint8    `json:"reconnect_retries"`
+	Keepalive        Duration `json:"keepalive"`
+
+	// Site specific maximum set points, on top of the controller's own, in its units. Zero leaves
+	// it up to the controller.
+	MaxPoolSetPoint uint `json:"max_pool_set_point"`
+	MaxSpaSetPoint  uint `json:"max_spa_set_point"`
+
+	// ReadOnly never sends the gateway anything that would change the controller's settings or
+	// turn anything on or off, no matter where the request came from.
+	ReadOnly bool `json:"read_only"`
+
+	// ClockSync is how often to set the controller's clock from ours. Zero disables it.
+	ClockSync    Duration `json:"clock_sync"`
+	AdjustForDST bool     `json:"adjust_for_dst"`
+}
+
+type HomeKitConfig struct {
+	Enabled      bool   `json:"enabled"`
+	Pin          string `json:"pin"`
+	StoragePath  string `json:"storage_path"`
+	Port         string `json:"port"`
+	Manufacturer string `json:"manufacturer"`
+
+	// SetupID is the 4 character id encoded in the setup QR code, along with the pin.
+	SetupID string `json:"setup_id"`
+
+	// IP to serve HomeKit on. This is only needed if the host has several addresses and the wrong one
+	// is being advertised.
+	IP string `json:"ip"`
+
+	// QRCode prints the setup QR code and X-HM URI to the terminal at startup.
+	QRCode bool `json:"qr_code"`
+
+	// SetPointDelay is how long to wait for the set point slider to stop moving before sending the
+	// new set point to the controller.
+	SetPointDelay Duration `json:"set_point_delay"`
+}
+
+type AccessoryConfig struct {
+	Enabled bool   `json:"enabled"`
+	Name    string `json:"name"`
+}
+
+type AirTemperatureConfig struct {
+	AccessoryConfig
+
+	// The range HomeKit allows the sensor to report, in celsius.
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+type LowSaltConfig struct {
+	AccessoryConfig
+
+	// MinPPM is the lowest salt level that isn't reported as low.
+	MinPPM uint32 `json:"min_ppm"`
+}
+
+type CircuitConfig struct {
+	ID   uint32 `json:"id"`
+	Name string `json:"name"`
+}
+
+type AccessoriesConfig struct {
+	AirTemperature AirTemperatureConfig `json:"air_temperature"`
+	Pool           AccessoryConfig      `json:"pool"`
+	Spa            AccessoryConfig      `json:"spa"`
+
+	FreezeProtection AccessoryConfig `json:"freeze_protection"`
+	ServiceMode      AccessoryConfig `json:"service_mode"`
+	Delay            AccessoryConfig `json:"delay"`
+
+	// Only exposed if the controller has an IntelliChem or a chlorinator, respectively.
+	ChemistryAlarm AccessoryConfig `json:"chemistry_alarm"`
+	LowSalt        LowSaltConfig   `json:"low_salt"`
+
+	// Circuits to expose as switches. Each circuit is named after its name on the controller,
+	// unless Name is set.
+	Circuits []CircuitConfig `json:"circuits"`
+
+	// AllCircuits exposes every circuit on the controller as a switch. Circuits still
+	// take their names from Circuits, if they're listed there.
+	AllCircuits bool `json:"all_circuits"`
+}
+
+type MQTTConfig struct {
+	Broker          string   `json:"broker"`
+	Username        string   `json:"username"`
+	Password        string   `json:"password"`
+	Topic           string   `json:"topic"`
+	DiscoveryPrefix string   `json:"discovery_prefix"`
+	Interval        Duration `json:"interval"`
+}
+
+// Config - everything that controls how the bridge behaves. Settings are applied in this order,
+// with later ones taking precedence: defaults, the config file, environment variables, then
+// command line flags.
+type Config struct {
+	Gateway     GatewayConfig     `json:"gateway"`
+	HomeKit     HomeKitConfig     `json:"homekit"`
+	Accessories AccessoriesConfig `json:"accessories"`
+	MetricsAddr string            `json:"metrics_addr"`
+	HTTPAddr    string            `json:"http_addr"`
+	MQTT        MQTTConfig        `json:"mqtt"`
+}
+
+func defaultConfig() *Config {
+	cfg := &Config{}
+
+	cfg.Gateway.ClientName = "screenlogic-homekit"
+	cfg.Gateway.CacheExpiry.Duration = time.Minute
+	cfg.Gateway.ReconnectRetries = 1
+	cfg.Gateway.Keepalive.Duration = 30 * time.Second
+	cfg.Gateway.AdjustForDST = true
+	cfg.Gateway.Dispatcher = screenlogic.DefaultDispatcherAddress
+
+	cfg.HomeKit.Enabled = true
+	cfg.HomeKit.Pin = "00102003"
+	cfg.HomeKit.Manufacturer = "Pentair"
+	cfg.HomeKit.SetPointDelay.Duration = 2 * time.Second
+	cfg.HomeKit.SetupID = "HOME"
+	cfg.HomeKit.QRCode = true
+
+	cfg.Accessories.AirTemperature = AirTemperatureConfig{
+		AccessoryConfig: AccessoryConfig{Enabled: true, Name: "Ambient Air Temperature"},
+		Min:             -40,
+		Max:             150,
+	}
+	cfg.Accessories.Pool = AccessoryConfig{Enabled: true, Name: "Pool"}
+	cfg.Accessories.Spa = AccessoryConfig{Enabled: true, Name: "Hot Tub"}
+	cfg.Accessories.FreezeProtection = AccessoryConfig{Enabled: true, Name: "Freeze Protection Active"}
+	cfg.Accessories.ServiceMode = AccessoryConfig{Enabled: true, Name: "Service Mode"}
+	cfg.Accessories.Delay = AccessoryConfig{Enabled: true, Name: "Equipment Delay"}
+	cfg.Accessories.ChemistryAlarm = AccessoryConfig{Enabled: true, Name: "Water Chemistry Alarm"}
+
+	// The bottom of the range Pentair recommends for its salt chlorine generators.
+	cfg.Accessories.LowSalt = LowSaltConfig{
+		AccessoryConfig: AccessoryConfig{Enabled: true, Name: "Low Salt"},
+		MinPPM:          2700,
+	}
+
+	cfg.MQTT.Topic = "screenlogic"
+	cfg.MQTT.DiscoveryPrefix = "homeassistant"
+	cfg.MQTT.Interval.Duration = 30 * time.Second
+
+	return cfg
+}
+
+// loadFile - merges the JSON config file at path into cfg. Anything not in the file is left as-is.
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	return nil
+}
+
+// loadEnv - overrides cfg with any of the SCREENLOGIC_* environment variables that are set.
+// These are mostly useful for keeping secrets out of the config file, or for containers.
+func (cfg *Config) loadEnv() {
+	vars := map[string]*string{
+		"SCREENLOGIC_GATEWAY_ADDRESS":      &cfg.Gateway.Address,
+		"SCREENLOGIC_GATEWAY_PASSWORD":     &cfg.Gateway.Password,
+		"SCREENLOGIC_GATEWAY_REMOTE_NAME":  &cfg.Gateway.RemoteName,
+		"SCREENLOGIC_CLIENT_NAME":          &cfg.Gateway.ClientName,
+		"SCREENLOGIC_HOMEKIT_PIN":          &cfg.HomeKit.Pin,
+		"SCREENLOGIC_HOMEKIT_STORAGE_PATH": &cfg.HomeKit.StoragePath,
+		"SCREENLOGIC_HOMEKIT_PORT":         &cfg.HomeKit.Port,
+		"SCREENLOGIC_HOMEKIT_SETUP_ID":     &cfg.HomeKit.SetupID,
+		"SCREENLOGIC_HOMEKIT_IP":           &cfg.HomeKit.IP,
+		"SCREENLOGIC_METRICS_ADDR":         &cfg.MetricsAddr,
+		"SCREENLOGIC_HTTP_ADDR":            &cfg.HTTPAddr,
+		"SCREENLOGIC_MQTT_BROKER":          &cfg.MQTT.Broker,
+		"SCREENLOGIC_MQTT_USERNAME":        &cfg.MQTT.Username,
+		"SCREENLOGIC_MQTT_PASSWORD":        &cfg.MQTT.Password,
+	}
+
+	for name, field := range vars {
+		value, ok := os.LookupEnv(name)
+		if ok {
+			*field = value
+		}
+	}
+}
+
+// bindFlags - registers command line flags that write directly into cfg.
+func (cfg *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Gateway.Address, "gateway", cfg.Gateway.Address, "gateway address as host[:port] (discovered on the local network if empty)")
+	fs.StringVar(&cfg.Gateway.RemoteName, "remote", cfg.Gateway.RemoteName, "name of a gateway to connect to through the pentair dispatcher, e.g. \"Pentair: 01-23-45\"")
+	fs.BoolVar(&cfg.Gateway.ReadOnly, "read-only", cfg.Gateway.ReadOnly, "never change anything on the controller, only monitor it")
+	fs.UintVar(&cfg.Gateway.MaxPoolSetPoint, "max-pool-set-point", cfg.Gateway.MaxPoolSetPoint, "highest pool set point to allow, in the controller's units (the controller's own maximum if 0)")
+	fs.UintVar(&cfg.Gateway.MaxSpaSetPoint, "max-spa-set-point", cfg.Gateway.MaxSpaSetPoint, "highest spa set point to allow, in the controller's units (the controller's own maximum if 0)")
+	fs.DurationVar(&cfg.Gateway.Keepalive.Duration, "keepalive", cfg.Gateway.Keepalive.Duration, "ping the gateway after it's been idle this long (disabled if 0)")
+	fs.DurationVar(&cfg.Gateway.ClockSync.Duration, "clock-sync", cfg.Gateway.ClockSync.Duration, "how often to set the controller's clock from this host's, e.g. 6h (disabled if 0)")
+	fs.StringVar(&cfg.HomeKit.Pin, "pin", cfg.HomeKit.Pin, "homekit pin code to use for this accessory")
+	fs.StringVar(&cfg.HomeKit.StoragePath, "storage-path", cfg.HomeKit.StoragePath, "directory to keep homekit pairing data in")
+	fs.StringVar(&cfg.HomeKit.Port, "port", cfg.HomeKit.Port, "port to serve homekit on (random if empty)")
+	fs.StringVar(&cfg.HomeKit.SetupID, "setup-id", cfg.HomeKit.SetupID, "homekit setup id, 4 uppercase letters or digits")
+	fs.StringVar(&cfg.HomeKit.IP, "ip", cfg.HomeKit.IP, "ip address to serve homekit on (all addresses if empty)")
+	fs.BoolVar(&cfg.HomeKit.QRCode, "qr", cfg.HomeKit.QRCode, "print the homekit setup qr code at startup")
+	fs.DurationVar(&cfg.HomeKit.SetPointDelay.Duration, "set-point-delay", cfg.HomeKit.SetPointDelay.Duration, "how long to wait for the homekit set point slider to stop moving before sending it")
+	fs.BoolVar(&cfg.Accessories.AllCircuits, "all-circuits", cfg.Accessories.AllCircuits, "expose every circuit on the controller as a homekit switch")
+	fs.BoolVar(&cfg.HomeKit.Enabled, "homekit", cfg.HomeKit.Enabled, "publish accessories over HomeKit")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "address to serve prometheus metrics on, e.g. :9100 (disabled if empty)")
+	fs.StringVar(&cfg.HTTPAddr, "http-addr", cfg.HTTPAddr, "address to serve the REST API on, e.g. :8080 (disabled if empty)")
+	fs.StringVar(&cfg.MQTT.Broker, "mqtt-broker", cfg.MQTT.Broker, "mqtt broker to publish to, e.g. tcp://localhost:1883 (disabled if empty)")
+	fs.StringVar(&cfg.MQTT.Username, "mqtt-username", cfg.MQTT.Username, "mqtt username")
+	fs.StringVar(&cfg.MQTT.Password, "mqtt-password", cfg.MQTT.Password, "mqtt password")
+	fs.StringVar(&cfg.MQTT.Topic, "mqtt-topic", cfg.MQTT.Topic, "mqtt topic to publish state under")
+	fs.StringVar(&cfg.MQTT.DiscoveryPrefix, "mqtt-discovery-prefix", cfg.MQTT.DiscoveryPrefix, "home assistant mqtt discovery prefix (discovery is disabled if empty)")
+	fs.DurationVar(&cfg.MQTT.Interval.Duration, "mqtt-interval", cfg.MQTT.Interval.Duration, "how often to publish state to mqtt")
+}
+
+// loadConfig - parses the command line, then builds the config from the defaults, the config
+// file (if -config was given), the environment, and any flags that were explicitly set.
+func loadConfig(args []string) (*Config, error) {
+	var path string
+
+	// The first pass is only to find out where the config file is, and which flags were set.
+	// Flag defaults here are just for the -help output.
+	cmdline := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	cmdline.StringVar(&path, "config", "", "path to a JSON config file")
+	defaultConfig().bindFlags(cmdline)
+
+	err := cmdline.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+
+	if path != "" {
+		err = cfg.loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.loadEnv()
+
+	overrides := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	cfg.bindFlags(overrides)
+
+	cmdline.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+
+		// These were already validated by the first pass, so this can't fail.
+		overrides.Set(f.Name, f.Value.String())
+	})
+
+	err = cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// validate - checks for mistakes that would otherwise only show up once HomeKit tries to pair.
+func (cfg *Config) validate() error {
+	if cfg.Gateway.RemoteName != "" && cfg.Gateway.Password == "" {
+		return fmt.Errorf("a password is required to connect to %q remotely", cfg.Gateway.RemoteName)
+	}
+
+	if cfg.Gateway.ReadOnly && cfg.Gateway.ClockSync.Duration > 0 {
+		return fmt.Errorf("clock_sync sets the controller's clock, so it can't be used with read_only")
+	}
+
+	if len(cfg.HomeKit.Pin) != 8 || strings.Trim(cfg.HomeKit.Pin, "0123456789") != "" {
+		return fmt.Errorf("invalid homekit pin %q, must be 8 digits", cfg.HomeKit.Pin)
+	}
+
+	if len(cfg.HomeKit.SetupID) != 4 {
+		return fmt.Errorf("invalid homekit setup id %q, must be 4 characters", cfg.HomeKit.SetupID)
+	}
+
+	for _, c := range cfg.HomeKit.SetupID {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return fmt.Errorf("invalid homekit setup id %q, must be uppercase letters or digits", cfg.HomeKit.SetupID)
+		}
+	}
+
+	if cfg.HomeKit.IP != "" && net.ParseIP(cfg.HomeKit.IP) == nil {
+		return fmt.Errorf("invalid homekit ip %q", cfg.HomeKit.IP)
+	}
+
+	return nil
+}
+
+func (cfg *Config) clientOptions() ClientOptions {
+	return ClientOptions{
+		ClientName:       cfg.Gateway.ClientName,
+		GatewayAddress:   cfg.Gateway.Address,
+		RemoteName:       cfg.Gateway.RemoteName,
+		Dispatcher:       cfg.Gateway.Dispatcher,
+		Password:         cfg.Gateway.Password,
+		CacheExpiry:      cfg.Gateway.CacheExpiry.Duration,
+		ReconnectRetries: cfg.Gateway.ReconnectRetries,
+		Keepalive:        cfg.Gateway.Keepalive.Duration,
+		ReadOnly:         cfg.Gateway.ReadOnly,
+		MaxPoolSetPoint:  uint32(cfg.Gateway.MaxPoolSetPoint),
+		MaxSpaSetPoint:   uint32(cfg.Gateway.MaxSpaSetPoint),
+	}
+}
+
+func (cfg *Config) mqttOptions() MQTTOptions {
+	return MQTTOptions{
+		Broker:          cfg.MQTT.Broker,
+		Username:        cfg.MQTT.Username,
+		Password:        cfg.MQTT.Password,
+		BaseTopic:       cfg.MQTT.Topic,
+		DiscoveryPrefix: cfg.MQTT.DiscoveryPrefix,
+		Interval:        cfg.MQTT.Interval.Duration,
+	}
+}
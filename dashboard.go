@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// dashboardHandler - serves the web dashboard. It's meant to be mounted at /dashboard/, one level
+// below the REST API it uses.
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		// This can only happen if the embed directive above doesn't match the path here.
+		panic(err)
+	}
+
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(files)))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/log"
+)
+
+// DelayAccessory - a switch that's on while the controller is holding equipment off with a pool, spa
+// or cleaner delay. Turning it off cancels the delays. Turning it on does nothing, since delays can only
+// be started by the controller.
+//
+// Unlike circuits, there's no RemainingDuration. PoolDelay, SpaDelay and CleanerDelay in the status are only
+// flags, nothing the gateway sends counts the delay down, and the controller doesn't say how long its delays
+// are, so there's nothing to count from either.
+type DelayAccessory struct {
+	*accessory.Switch
+
+	client *Client
+}
+
+func NewDelayAccessory(client *Client, name string, manufacturer string) (*DelayAccessory, error) {
+	info := accessory.Info{
+		Name:         name,
+		Manufacturer: manufacturer,
+	}
+
+	delay := &DelayAccessory{
+		Switch: accessory.NewSwitch(info),
+
+		client: client,
+	}
+
+	active, err := client.GetDelayActive()
+	if err != nil {
+		return nil, err
+	}
+
+	delay.Switch.Switch.On.SetValue(active)
+	delay.Switch.Switch.On.OnValueRemoteGet(remoteGetBool("delay", delay.Switch.Switch.On.Bool, client.GetDelayActive))
+	delay.Switch.Switch.On.OnValueRemoteUpdate(delay.setState)
+
+	addStatusFault(delay.Switch.Switch.Service, client)
+
+	return delay, nil
+}
+
+func (da *DelayAccessory) setState(on bool) {
+	if on {
+		// Put it back, the next read will show the real state anyway.
+		active, err := da.client.GetDelayActive()
+		if err != nil {
+			log.Info.Printf("delay: %v\n", err)
+			return
+		}
+
+		da.Switch.Switch.On.SetValue(active)
+		return
+	}
+
+	err := da.client.CancelDelay()
+	if err != nil {
+		log.Info.Printf("delay: %v\n", err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+	"github.com/brutella/hc/log"
+)
+
+// StatusChange - a single field that differs between two pool statuses.
+type StatusChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// StatusEvent - what gets sent to stream subscribers whenever the pool status changes.
+type StatusEvent struct {
+	Time    time.Time               `json:"time"`
+	Changes []StatusChange          `json:"changes"`
+	Status  *screenlogic.PoolStatus `json:"status"`
+}
+
+// flattenPoolStatus - turns status into a flat map of field name to value, so two statuses can
+// easily be compared field-by-field. Field names are what show up in StatusChange.Field.
+func flattenPoolStatus(status *screenlogic.PoolStatus) map[string]interface{} {
+	fields := map[string]interface{}{
+		"controller_state":     status.OK,
+		"freeze_mode":          status.FreezeMode != 0,
+		"pool_delay":           status.PoolDelay,
+		"spa_delay":            status.SpaDelay,
+		"cleaner_delay":        status.CleanerDelay,
+		"air_temp":             status.AirTemp,
+		"chemistry.ph":         status.Chemistry.PH,
+		"chemistry.orp":        status.Chemistry.ORP,
+		"chemistry.saturation": status.Chemistry.Saturation,
+		"chemistry.salt_ppm":   status.Chemistry.SaltPPM,
+		"chemistry.ph_tank":    status.Chemistry.PHTankLevel,
+		"chemistry.orp_tank":   status.Chemistry.ORPTankLevel,
+		"chemistry.alarms":     status.Chemistry.Alarms,
+	}
+
+	for name, body := range map[string]*protocol.BodyOfWater{"pool": status.PoolWater(), "spa": status.SpaWater()} {
+		if body == nil {
+			continue
+		}
+
+		fields[name+".current_temp"] = body.CurrentTemp
+		fields[name+".heater_on"] = body.HeaterStatus != 0
+		fields[name+".heat_set_point"] = body.HeatSetPoint
+		fields[name+".cool_set_point"] = body.CoolSetPoint
+		fields[name+".heat_mode"] = screenlogic.HeatMode(body.HeatMode).String()
+	}
+
+	for _, circuit := range status.Circuits {
+		fields["circuits."+strconv.Itoa(int(circuit.ID))+".on"] = circuit.ValveState != 0
+	}
+
+	return fields
+}
+
+// diffPoolStatus - returns every field that differs between previous and current, sorted by field name.
+// Fields that only exist in one of the two statuses (like a circuit that appeared) are included, with
+// the missing side set to nil.
+func diffPoolStatus(previous, current *screenlogic.PoolStatus) []StatusChange {
+	before := flattenPoolStatus(previous)
+	after := flattenPoolStatus(current)
+
+	var changes []StatusChange
+
+	for field, newValue := range after {
+		oldValue, ok := before[field]
+		if !ok || oldValue != newValue {
+			changes = append(changes, StatusChange{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+
+	for field, oldValue := range before {
+		if _, ok := after[field]; !ok {
+			changes = append(changes, StatusChange{Field: field, Old: oldValue, New: nil})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes
+}
+
+// StatusStream - serves a Server-Sent Events stream of pool status changes.
+//
+// When a client connects it's sent a "status" event with the current status, then a "change"
+// event (a StatusEvent) every time a refreshed status differs from the one before it, and an
+// "error" event (a StreamError) every time refreshing it fails.
+type StatusStream struct {
+	client   *Client
+	interval time.Duration
+
+	mutex       sync.Mutex
+	subscribers map[chan streamEvent]struct{}
+}
+
+// StreamError - what gets sent to stream subscribers when the status couldn't be refreshed.
+type StreamError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+type streamEvent struct {
+	name string
+	data []byte
+}
+
+// NewStatusStream - interval is how often we ask the client for the pool status while anyone is
+// subscribed. The client's cache still decides how often that actually goes to the gateway.
+func NewStatusStream(client *Client, interval time.Duration) *StatusStream {
+	ss := &StatusStream{
+		client:      client,
+		interval:    interval,
+		subscribers: make(map[chan streamEvent]struct{}),
+	}
+
+	client.OnStatusRefresh(ss.onStatusRefresh)
+
+	go ss.pollLoop()
+
+	return ss
+}
+
+func (ss *StatusStream) onStatusRefresh(previous, current *screenlogic.PoolStatus) {
+	if previous == nil {
+		return
+	}
+
+	changes := diffPoolStatus(previous, current)
+	if len(changes) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(&StatusEvent{
+		Time:    time.Now(),
+		Changes: changes,
+		Status:  current,
+	})
+	if err != nil {
+		log.Info.Printf("events: %v\n", err)
+		return
+	}
+
+	ss.broadcast("change", data)
+}
+
+// broadcast - sends an event to every subscriber.
+func (ss *StatusStream) broadcast(name string, data []byte) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	for ch := range ss.subscribers {
+		// This can be called with the client's request lock held, so never block here.
+		// A subscriber that can't keep up just misses events.
+		select {
+		case ch <- streamEvent{name: name, data: data}:
+		default:
+		}
+	}
+}
+
+func (ss *StatusStream) pollLoop() {
+	ticker := time.NewTicker(ss.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ss.mutex.Lock()
+		subscribed := len(ss.subscribers) > 0
+		ss.mutex.Unlock()
+
+		if !subscribed {
+			continue
+		}
+
+		_, err := ss.client.getPoolStatus()
+		if err != nil {
+			log.Info.Printf("events: %v\n", err)
+
+			data, err := json.Marshal(&StreamError{Time: time.Now(), Error: err.Error()})
+			if err != nil {
+				log.Info.Printf("events: %v\n", err)
+				continue
+			}
+
+			ss.broadcast("error", data)
+		}
+	}
+}
+
+func (ss *StatusStream) subscribe() chan streamEvent {
+	ch := make(chan streamEvent, 16)
+
+	ss.mutex.Lock()
+	ss.subscribers[ch] = struct{}{}
+	ss.mutex.Unlock()
+
+	return ch
+}
+
+func (ss *StatusStream) unsubscribe(ch chan streamEvent) {
+	ss.mutex.Lock()
+	delete(ss.subscribers, ch)
+	ss.mutex.Unlock()
+}
+
+func (ss *StatusStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	status, err := ss.client.getPoolStatus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	initial, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ch := ss.subscribe()
+	defer ss.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	fmt.Fprintf(w, "event: status\ndata: %s\n\n", initial)
+	flusher.Flush()
+
+	// Send a comment every so often so proxies don't time out the connection while the pool is quiet.
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprintf(w, ": keepalive\n\n")
+			flusher.Flush()
+		case event := <-ch:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+			flusher.Flush()
+		}
+	}
+}
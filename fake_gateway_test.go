@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+// fakeGateway - just enough of a gateway to run a Client against. Each request is answered by the handler
+// registered for its type code, or with an empty reply of the next type code if there isn't one, which is
+// what the gateway sends for most requests that change something.
+type fakeGateway struct {
+	t        *testing.T
+	listener net.Listener
+
+	mutex    sync.Mutex
+	handlers map[uint16]fakeHandler
+	requests []fakePacket
+	conns    []net.Conn
+}
+
+type fakePacket struct {
+	TypeCode uint16
+	Body     []byte
+}
+
+// fakeHandler - returns the packets to send back for req, in order. Any packet other than the reply
+// itself is taken by the client as an out of band one.
+type fakeHandler func(req fakePacket) []fakePacket
+
+const fakeGatewayMacAddr = "00-11-22-33-44-55"
+
+func newFakeGateway(t *testing.T) *fakeGateway {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fg := &fakeGateway{
+		t:        t,
+		listener: listener,
+		handlers: make(map[uint16]fakeHandler),
+	}
+
+	fg.handle(protocol.ChallengePacketCode, func(req fakePacket) []fakePacket {
+		return []fakePacket{{protocol.ChallengePacketResponseCode, encodeBody(func(e *protocol.Encoder) {
+			e.WriteString(fakeGatewayMacAddr)
+		})}}
+	})
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go fg.serve()
+
+	return fg
+}
+
+// handle - answers requests with typeCode using h from now on.
+func (fg *fakeGateway) handle(typeCode uint16, h fakeHandler) {
+	fg.mutex.Lock()
+	defer fg.mutex.Unlock()
+
+	fg.handlers[typeCode] = h
+}
+
+// reply - answers requests with typeCode with body, under the next type code.
+func (fg *fakeGateway) reply(typeCode uint16, body []byte) {
+	fg.handle(typeCode, func(req fakePacket) []fakePacket {
+		return []fakePacket{{typeCode + 1, body}}
+	})
+}
+
+// received - every request with typeCode so far.
+func (fg *fakeGateway) received(typeCode uint16) []fakePacket {
+	fg.mutex.Lock()
+	defer fg.mutex.Unlock()
+
+	var requests []fakePacket
+
+	for _, req := range fg.requests {
+		if req.TypeCode == typeCode {
+			requests = append(requests, req)
+		}
+	}
+
+	return requests
+}
+
+// newClient - a Client connected to this gateway. Anything not set in options gets a default suited to tests.
+func (fg *fakeGateway) newClient(options ClientOptions) *Client {
+	options.GatewayAddress = fg.listener.Addr().String()
+
+	if options.CacheExpiry == 0 {
+		options.CacheExpiry = time.Hour
+	}
+
+	client, err := NewConnectedClient(options)
+	if err != nil {
+		fg.t.Fatal(err)
+	}
+
+	fg.t.Cleanup(client.gateway.Close)
+
+	return client
+}
+
+// shutdown - drops every connection and stops accepting new ones, like a gateway that lost power.
+func (fg *fakeGateway) shutdown() {
+	fg.listener.Close()
+
+	fg.mutex.Lock()
+	defer fg.mutex.Unlock()
+
+	for _, conn := range fg.conns {
+		conn.Close()
+	}
+}
+
+func (fg *fakeGateway) serve() {
+	for {
+		conn, err := fg.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		fg.mutex.Lock()
+		fg.conns = append(fg.conns, conn)
+		fg.mutex.Unlock()
+
+		go fg.serveConn(conn)
+	}
+}
+
+func (fg *fakeGateway) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	connect := make([]byte, len("CONNECTSERVERHOST\r\n\r\n"))
+
+	_, err := io.ReadFull(conn, connect)
+	if err != nil {
+		return
+	}
+
+	for {
+		var header protocol.PacketHeader
+
+		err = binary.Read(conn, binary.LittleEndian, &header)
+		if err != nil {
+			return
+		}
+
+		req := fakePacket{TypeCode: header.TypeID, Body: make([]byte, header.Len)}
+
+		_, err = io.ReadFull(conn, req.Body)
+		if err != nil {
+			return
+		}
+
+		fg.mutex.Lock()
+		fg.requests = append(fg.requests, req)
+		h := fg.handlers[req.TypeCode]
+		fg.mutex.Unlock()
+
+		replies := []fakePacket{{TypeCode: req.TypeCode + 1}}
+		if h != nil {
+			replies = h(req)
+		}
+
+		var buf bytes.Buffer
+
+		for _, reply := range replies {
+			binary.Write(&buf, binary.LittleEndian, protocol.PacketHeader{
+				Sequence: header.Sequence,
+				TypeID:   reply.TypeCode,
+				Len:      uint32(len(reply.Body)),
+			})
+
+			buf.Write(reply.Body)
+		}
+
+		_, err = buf.WriteTo(conn)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// encodeBody - the packet body fn writes.
+func encodeBody(fn func(e *protocol.Encoder)) []byte {
+	var buf bytes.Buffer
+
+	fn(protocol.NewEncoder(&buf))
+
+	return buf.Bytes()
+}
+
+// newFakePool - a fake gateway for a dual body pool and spa in fahrenheit, with a few circuits. Tests can
+// change the configuration or status with setConfig and setStatus.
+func newFakePool(t *testing.T) *fakeGateway {
+	fg := newFakeGateway(t)
+
+	fg.reply(protocol.VersionPacketCode, encodeBody(func(e *protocol.Encoder) {
+		e.WriteString("POOL: 5.2 Build 738.0 Rel")
+
+		for i := 0; i < 6; i++ {
+			e.WriteUint32(0)
+		}
+	}))
+
+	fg.reply(protocol.GetCustomNamesPacketCode, encodeBody(func(e *protocol.Encoder) {
+		e.WriteUint32(0)
+	}))
+
+	fg.setConfig(fakePoolConfig())
+	fg.setStatus(fakePoolStatus())
+
+	return fg
+}
+
+func fakePoolConfig() *protocol.ControllerConfigurationResponsePacket {
+	return &protocol.ControllerConfigurationResponsePacket{
+		ControllerID:             100,
+		AllowedPoolSetPointRange: protocol.SetPoint{Min: 40, Max: 104},
+		AllowedSpaSetPointRange:  protocol.SetPoint{Min: 40, Max: 104},
+		ControllerType:           protocol.ControllerTypeIntelliTouchDualBody,
+		EquipmentFlags:           protocol.EquipmentFlagSolar,
+		Circuits: []protocol.ControllerCircuit{
+			{ID: 500, Name: "Spa", Function: protocol.CircuitFunctionSpa, Interface: protocol.CircuitInterfaceSpa},
+			{ID: 505, Name: "Pool", Function: protocol.CircuitFunctionPool, Interface: protocol.CircuitInterfacePool},
+			{ID: 501, Name: "Pool Light", Function: protocol.CircuitFunctionLight, Interface: protocol.CircuitInterfaceLights},
+		},
+	}
+}
+
+func fakePoolStatus() *protocol.PoolStatusResponsePacket {
+	return &protocol.PoolStatusResponsePacket{
+		OK:      1,
+		AirTemp: 75,
+		Bodies: []protocol.BodyOfWater{
+			{Type: 0, CurrentTemp: 82, HeatSetPoint: 84, HeatMode: 3},
+			{Type: 1, CurrentTemp: 99, HeatSetPoint: 102, HeatMode: 0},
+		},
+		Circuits: []protocol.PoolCircuit{
+			{ID: 500, ValveState: 0},
+			{ID: 505, ValveState: 1},
+			{ID: 501, ValveState: 0},
+		},
+	}
+}
+
+func (fg *fakeGateway) setConfig(cc *protocol.ControllerConfigurationResponsePacket) {
+	fg.reply(protocol.ControllerConfigurationPacketCode, encodeBody(func(e *protocol.Encoder) {
+		e.WriteUint32(cc.ControllerID)
+		e.WriteUint8(cc.AllowedPoolSetPointRange.Min)
+		e.WriteUint8(cc.AllowedPoolSetPointRange.Max)
+		e.WriteUint8(cc.AllowedSpaSetPointRange.Min)
+		e.WriteUint8(cc.AllowedSpaSetPointRange.Max)
+		e.WriteUint8(boolByte(cc.IsCelcius))
+		e.WriteUint8(uint8(cc.ControllerType))
+		e.WriteUint8(uint8(cc.HardwareType))
+		e.WriteUint8(cc.ControllerBuffer)
+		e.WriteUint32(uint32(cc.EquipmentFlags))
+		e.WriteString(cc.DefaultCircuitName)
+		e.WriteUint32(uint32(len(cc.Circuits)))
+
+		for _, circuit := range cc.Circuits {
+			e.WriteUint32(circuit.ID)
+			e.WriteString(circuit.Name)
+			e.WriteUint8(circuit.NameIndex)
+			e.WriteUint8(uint8(circuit.Function))
+			e.WriteUint8(uint8(circuit.Interface))
+			e.WriteUint8(uint8(circuit.Flags))
+			e.WriteUint8(circuit.ColorSet)
+			e.WriteUint8(circuit.ColorPosition)
+			e.WriteUint8(circuit.ColorStagger)
+			e.WriteUint8(circuit.DeviceID)
+			e.WriteUint16(circuit.DefaultRT)
+			e.WriteUint16(0)
+		}
+
+		e.WriteUint32(0) // colors
+
+		for _, pump := range cc.Pumps {
+			e.WriteUint8(pump.Data)
+		}
+
+		e.WriteUint32(cc.InterfaceTabFlags)
+		e.WriteUint8(boolByte(cc.ShowAlarms))
+	}))
+}
+
+func (fg *fakeGateway) setStatus(ps *protocol.PoolStatusResponsePacket) {
+	fg.reply(protocol.PoolStatusPacketCode, encodeBody(func(e *protocol.Encoder) {
+		e.WriteUint32(ps.OK)
+		e.WriteUint8(ps.FreezeMode)
+		e.WriteUint8(ps.Remotes)
+		e.WriteUint8(ps.PoolDelay)
+		e.WriteUint8(ps.SpaDelay)
+		e.WriteUint8(ps.CleanerDelay)
+		e.WriteBytes(ps.WhoKnows[:])
+		e.WriteUint32(ps.AirTemp)
+		e.WriteUint32(uint32(len(ps.Bodies)))
+
+		for _, body := range ps.Bodies {
+			for _, v := range []uint32{body.Type, body.CurrentTemp, body.HeaterStatus, body.HeatSetPoint, body.CoolSetPoint, body.HeatMode} {
+				e.WriteUint32(v)
+			}
+		}
+
+		e.WriteUint32(uint32(len(ps.Circuits)))
+
+		for _, circuit := range ps.Circuits {
+			e.WriteUint32(circuit.ID)
+			e.WriteUint32(circuit.ValveState)
+			e.WriteUint8(circuit.ColorSet)
+			e.WriteUint8(circuit.ColorPosition)
+			e.WriteUint8(circuit.ColorStagger)
+			e.WriteUint8(circuit.Delay)
+		}
+
+		e.WriteUint32(uint32(ps.Chemistry.PH * 100))
+		e.WriteUint32(ps.Chemistry.ORP)
+		e.WriteUint32(uint32(ps.Chemistry.Saturation * 100))
+		e.WriteUint32(ps.Chemistry.SaltPPM)
+		e.WriteUint32(ps.Chemistry.PHTankLevel)
+		e.WriteUint32(ps.Chemistry.ORPTankLevel)
+		e.WriteUint32(ps.Chemistry.Alarms)
+	}))
+}
+
+// lastRequest - the body of the last request with typeCode as uint32s, which is how most requests that
+// change something are laid out.
+func (fg *fakeGateway) lastRequest(typeCode uint16) []uint32 {
+	requests := fg.received(typeCode)
+	if len(requests) == 0 {
+		return nil
+	}
+
+	body := requests[len(requests)-1].Body
+
+	vals := make([]uint32, len(body)/4)
+	for i := range vals {
+		vals[i] = binary.LittleEndian.Uint32(body[i*4:])
+	}
+
+	return vals
+}
+
+func boolByte(b bool) uint8 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -5,6 +5,8 @@ go 1.16
 require (
 	github.com/brutella/hc v1.2.4
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/mdp/qrterminal/v3 v3.0.0
 	github.com/miekg/dns v1.1.40 // indirect
 	github.com/stretchr/testify v1.7.0 // indirect
 	github.com/xiam/to v0.0.0-20200126224905-d60d31e03561 // indirect
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+	"github.com/brutella/hc/log"
+)
+
+// APIHandler - a small REST/JSON API for reading and controlling the pool over HTTP.
+//
+//	GET /status
+//	GET /config
+//	GET /weather
+//	GET /health
+//	GET /history?from=&to=[&format=json|jsonl|csv][&units=C|F]
+//	PUT /bodies/{pool|spa}/setpoint   {"temperature": 84}
+//	PUT /bodies/{pool|spa}/heatmode   {"mode": "off|solar|solar-preferred|on"}
+//	PUT /circuits/{id}                {"on": true}
+//	PUT /names/{index}                {"name": "Waterfall"}
+//
+// Times for /history are YYYY-MM-DD or RFC3339, at most maxHistorySpan apart. Temperatures are in the
+// controller's units.
+// Successful PUTs respond with 204 No Content, or 403 Forbidden when the bridge is read-only.
+// Errors respond with {"error": "..."}.
+type APIHandler struct {
+	client *Client
+}
+
+func NewAPIHandler(client *Client) *APIHandler {
+	return &APIHandler{client: client}
+}
+
+type apiError struct {
+	status int
+	err    error
+}
+
+func (ae *apiError) Error() string {
+	return ae.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+var notFoundErr = &apiError{http.StatusNotFound, errors.New("not found")}
+var methodNotAllowedErr = &apiError{http.StatusMethodNotAllowed, errors.New("method not allowed")}
+
+func (ah *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := ah.route(w, r)
+	if err == nil {
+		return
+	}
+
+	status := http.StatusBadGateway
+
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		status = apiErr.status
+	} else if errors.Is(err, screenlogic.ReadOnlyErr) {
+		status = http.StatusForbidden
+	} else if errors.Is(err, screenlogic.SetPointOutOfRangeErr) || errors.Is(err, screenlogic.NoSolarErr) {
+		status = http.StatusBadRequest
+	} else if errors.Is(err, protocol.BadParameterErr) {
+		// The gateway refused something in the request, like a custom name index past the end of the table.
+		status = http.StatusBadRequest
+	} else {
+		// Anything that isn't an apiError came from the gateway.
+		log.Info.Printf("api: %s %s: %v\n", r.Method, r.URL.Path, err)
+	}
+
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (ah *APIHandler) route(w http.ResponseWriter, r *http.Request) error {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "status":
+		return ah.onlyMethod(r, http.MethodGet, func() error { return ah.getStatus(w, r) })
+	case len(parts) == 1 && parts[0] == "config":
+		return ah.onlyMethod(r, http.MethodGet, func() error { return ah.getConfig(w, r) })
+	case len(parts) == 1 && parts[0] == "health":
+		return ah.onlyMethod(r, http.MethodGet, func() error { return ah.getHealth(w, r) })
+	case len(parts) == 1 && parts[0] == "weather":
+		return ah.onlyMethod(r, http.MethodGet, func() error { return ah.getWeather(w, r) })
+	case len(parts) == 1 && parts[0] == "history":
+		return ah.onlyMethod(r, http.MethodGet, func() error { return ah.getHistory(w, r) })
+	case len(parts) == 3 && parts[0] == "bodies" && parts[2] == "setpoint":
+		return ah.onlyMethod(r, http.MethodPut, func() error { return ah.putSetPoint(w, r, parts[1]) })
+	case len(parts) == 3 && parts[0] == "bodies" && parts[2] == "heatmode":
+		return ah.onlyMethod(r, http.MethodPut, func() error { return ah.putHeatMode(w, r, parts[1]) })
+	case len(parts) == 2 && parts[0] == "circuits":
+		return ah.onlyMethod(r, http.MethodPut, func() error { return ah.putCircuit(w, r, parts[1]) })
+	case len(parts) == 2 && parts[0] == "names":
+		return ah.onlyMethod(r, http.MethodPut, func() error { return ah.putCustomName(w, r, parts[1]) })
+	default:
+		return notFoundErr
+	}
+}
+
+func (ah *APIHandler) onlyMethod(r *http.Request, method string, fn func() error) error {
+	if r.Method != method {
+		return methodNotAllowedErr
+	}
+
+	return fn()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Info.Printf("api: %v\n", err)
+	}
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+
+	return nil
+}
+
+func (ah *APIHandler) getStatus(w http.ResponseWriter, r *http.Request) error {
+	status, err := ah.client.getPoolStatus()
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, status)
+
+	return nil
+}
+
+func (ah *APIHandler) getConfig(w http.ResponseWriter, r *http.Request) error {
+	config, err := ah.client.getControllerConfig()
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, config)
+
+	return nil
+}
+
+// getHealth - never goes to the gateway, so it answers even when the gateway doesn't.
+func (ah *APIHandler) getHealth(w http.ResponseWriter, r *http.Request) error {
+	health := ah.client.GetConnectionHealth()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"last_round_trip": health.LastRoundTrip,
+		"rtt_ms":          float64(health.RTT.Microseconds()) / 1000,
+	})
+
+	return nil
+}
+
+func (ah *APIHandler) getWeather(w http.ResponseWriter, r *http.Request) error {
+	forecast, err := ah.client.GetWeatherForecast()
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, forecast)
+
+	return nil
+}
+
+// maxHistorySpan - the longest range /history will fetch. Every other request waits while it's fetched,
+// a chunk at a time, so longer ranges should be fetched with slctl instead.
+const maxHistorySpan = 31 * 24 * time.Hour
+
+func parseAPITime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err == nil {
+		return t, nil
+	}
+
+	t, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, badRequest("invalid time %q, expected YYYY-MM-DD or RFC3339", value)
+	}
+
+	return t, nil
+}
+
+func (ah *APIHandler) getHistory(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	now := time.Now()
+
+	start, err := parseAPITime(query.Get("from"), now.Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	end, err := parseAPITime(query.Get("to"), now)
+	if err != nil {
+		return err
+	}
+
+	if !start.Before(end) {
+		return badRequest("from must be before to")
+	}
+
+	if end.Sub(start) > maxHistorySpan {
+		return badRequest("from and to can be at most %d days apart", maxHistorySpan/(24*time.Hour))
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	if format != "json" && format != "jsonl" && format != "csv" {
+		return badRequest("invalid format %q, expected json, jsonl or csv", format)
+	}
+
+	// json is the packet as the gateway sent it, whole degrees in the controller's units, so there's
+	// nothing to convert.
+	if format == "json" && query.Get("units") != "" {
+		return badRequest("units only applies to the jsonl and csv formats")
+	}
+
+	config, err := ah.client.getControllerConfig()
+	if err != nil {
+		return err
+	}
+
+	units := config.Units()
+
+	switch strings.ToUpper(query.Get("units")) {
+	case "":
+	case "C":
+		units = screenlogic.Celsius
+	case "F":
+		units = screenlogic.Fahrenheit
+	default:
+		return badRequest("invalid units %q, expected C or F", query.Get("units"))
+	}
+
+	history, err := ah.client.GetHistory(start, end)
+	if err != nil {
+		return err
+	}
+
+	records := screenlogic.HistoryRecords(history, config.Units(), units)
+
+	switch format {
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		return screenlogic.WriteHistoryJSONLines(w, records)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		return screenlogic.WriteHistoryCSV(w, records)
+	default:
+		writeJSON(w, http.StatusOK, history)
+		return nil
+	}
+}
+
+func parseAPIBody(name string) (screenlogic.BodyOfWater, error) {
+	switch name {
+	case "pool":
+		return screenlogic.BodyOfWaterPool, nil
+	case "spa":
+		return screenlogic.BodyOfWaterSpa, nil
+	default:
+		return 0, notFoundErr
+	}
+}
+
+func (ah *APIHandler) putSetPoint(w http.ResponseWriter, r *http.Request, bodyName string) error {
+	body, err := parseAPIBody(bodyName)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Temperature *uint32 `json:"temperature"`
+	}
+
+	err = readJSON(r, &req)
+	if err != nil {
+		return err
+	}
+
+	if req.Temperature == nil {
+		return badRequest("temperature is required")
+	}
+
+	err = ah.client.SetTemperature(body, *req.Temperature)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (ah *APIHandler) putHeatMode(w http.ResponseWriter, r *http.Request, bodyName string) error {
+	body, err := parseAPIBody(bodyName)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Mode string `json:"mode"`
+	}
+
+	err = readJSON(r, &req)
+	if err != nil {
+		return err
+	}
+
+	mode, err := screenlogic.ParseHeatMode(req.Mode)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+
+	err = ah.client.SetHeatMode(body, mode)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (ah *APIHandler) putCircuit(w http.ResponseWriter, r *http.Request, id string) error {
+	circuitID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return notFoundErr
+	}
+
+	config, err := ah.client.getControllerConfig()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, circuit := range config.Circuits {
+		if circuit.ID == uint32(circuitID) {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return notFoundErr
+	}
+
+	var req struct {
+		On *bool `json:"on"`
+	}
+
+	err = readJSON(r, &req)
+	if err != nil {
+		return err
+	}
+
+	if req.On == nil {
+		return badRequest("on is required")
+	}
+
+	err = ah.client.SetCircuitState(uint32(circuitID), *req.On)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (ah *APIHandler) putCustomName(w http.ResponseWriter, r *http.Request, index string) error {
+	idx, err := strconv.ParseUint(index, 10, 32)
+	if err != nil {
+		return notFoundErr
+	}
+
+	var req struct {
+		Name *string `json:"name"`
+	}
+
+	err = readJSON(r, &req)
+	if err != nil {
+		return err
+	}
+
+	if req.Name == nil || *req.Name == "" {
+		return badRequest("name is required")
+	}
+
+	err = ah.client.SetCustomName(uint32(idx), *req.Name)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+// serveAPI - the response to method path with body, from an APIHandler for client.
+func serveAPI(t *testing.T, client *Client, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+
+	NewAPIHandler(client).ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+	return rec
+}
+
+func TestAPIGets(t *testing.T) {
+	client := newFakePool(t).newClient(ClientOptions{})
+
+	for _, path := range []string{"/status", "/config", "/health"} {
+		t.Run(path, func(t *testing.T) {
+			rec := serveAPI(t, client, http.MethodGet, path, "")
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+
+			var v map[string]interface{}
+
+			err := json.Unmarshal(rec.Body.Bytes(), &v)
+			if err != nil {
+				t.Fatalf("%v: %s", err, rec.Body)
+			}
+		})
+	}
+}
+
+func TestAPIPuts(t *testing.T) {
+	fg := newFakePool(t)
+	client := fg.newClient(ClientOptions{})
+
+	tests := []struct {
+		path     string
+		body     string
+		typeCode uint16
+		want     []uint32
+	}{
+		{"/bodies/spa/setpoint", `{"temperature": 101}`, protocol.SetHeatPointPacketCode, []uint32{0, 1, 101}},
+		{"/bodies/pool/heatmode", `{"mode": "solar"}`, protocol.SetHeatModePacketCode, []uint32{0, 0, 1}},
+		{"/circuits/501", `{"on": true}`, protocol.SetCircuitStatePacketCode, []uint32{0, 501, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := serveAPI(t, client, http.MethodPut, tt.path, tt.body)
+
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body)
+			}
+
+			if got := fg.lastRequest(tt.typeCode); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gateway got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	fg := newFakePool(t)
+
+	config := fakePoolConfig()
+	config.EquipmentFlags = 0
+	fg.setConfig(config)
+
+	fg.handle(protocol.SetCustomNamePacketCode, func(req fakePacket) []fakePacket {
+		return []fakePacket{{TypeCode: protocol.BadParameterCode}}
+	})
+
+	client := fg.newClient(ClientOptions{})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"unknown route", http.MethodGet, "/pumps", "", http.StatusNotFound},
+		{"wrong method", http.MethodPost, "/status", "", http.StatusMethodNotAllowed},
+		{"unknown body", http.MethodPut, "/bodies/hot_tub/setpoint", `{"temperature": 100}`, http.StatusNotFound},
+		{"unknown circuit", http.MethodPut, "/circuits/999", `{"on": true}`, http.StatusNotFound},
+		{"invalid json", http.MethodPut, "/circuits/501", `{"on": `, http.StatusBadRequest},
+		{"unknown field", http.MethodPut, "/circuits/501", `{"on": true, "dim": 5}`, http.StatusBadRequest},
+		{"missing field", http.MethodPut, "/bodies/spa/setpoint", `{}`, http.StatusBadRequest},
+		{"set point out of range", http.MethodPut, "/bodies/spa/setpoint", `{"temperature": 110}`, http.StatusBadRequest},
+		{"invalid heat mode", http.MethodPut, "/bodies/pool/heatmode", `{"mode": "warm"}`, http.StatusBadRequest},
+		{"solar without solar", http.MethodPut, "/bodies/pool/heatmode", `{"mode": "solar-preferred"}`, http.StatusBadRequest},
+		{"history backwards", http.MethodGet, "/history?from=2026-07-04&to=2026-07-01", "", http.StatusBadRequest},
+		{"history too long", http.MethodGet, "/history?from=2026-01-01&to=2026-03-01", "", http.StatusBadRequest},
+		{"history invalid time", http.MethodGet, "/history?from=yesterday", "", http.StatusBadRequest},
+		{"history units as json", http.MethodGet, "/history?units=C", "", http.StatusBadRequest},
+		{"rejected by the gateway", http.MethodPut, "/names/40", `{"name": "Waterfall"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAPI(t, client, tt.method, tt.path, tt.body)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			var v struct {
+				Error string `json:"error"`
+			}
+
+			err := json.Unmarshal(rec.Body.Bytes(), &v)
+			if err != nil || v.Error == "" {
+				t.Errorf("no error in %s", rec.Body)
+			}
+		})
+	}
+
+	for _, typeCode := range []uint16{protocol.SetHeatPointPacketCode, protocol.SetHeatModePacketCode, protocol.SetCircuitStatePacketCode, protocol.HistoryPacketCode} {
+		if len(fg.received(typeCode)) > 0 {
+			t.Errorf("request with type code %d was sent to the gateway", typeCode)
+		}
+	}
+}
+
+func TestAPIReadOnly(t *testing.T) {
+	fg := newFakePool(t)
+	client := fg.newClient(ClientOptions{ReadOnly: true})
+
+	rec := serveAPI(t, client, http.MethodPut, "/circuits/501", `{"on": true}`)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body)
+	}
+
+	rec = serveAPI(t, client, http.MethodGet, "/status", "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
@@ -1,50 +1,115 @@
 package main
 
 import (
-	"flag"
+	"fmt"
+	"net/http"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
 	"github.com/brutella/hc"
 	"github.com/brutella/hc/accessory"
 	"github.com/brutella/hc/log"
+	"github.com/mdp/qrterminal/v3"
 )
 
-var pinCode string
-
-const pinCodeDefault = "00102003"
-
 func main() {
 	// Enable debug logging in github.com/brutella/hc as well as in this codebase.
 	//
 	// log.Debug.Enable()
 
-	flag.StringVar(&pinCode, "pin", pinCodeDefault, "homekit pin code to use for this accessory")
-
-	flag.Parse()
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Info.Fatal(err)
+	}
 
-	client, err := NewConnectedClient("screenlogic-homekit")
+	client, err := NewConnectedClient(cfg.clientOptions())
 	if err != nil {
 		log.Debug.Fatal(err)
 	}
 
-	airTempInfo := accessory.Info{
-		Manufacturer: "Pentair",
-		Model:        "ScreenLogic",
-		Name:         "Ambient Air Temperature",
+	// The metrics and API servers can share an address, so group handlers by the address they're served on.
+	servers := make(map[string]*http.ServeMux)
+
+	serverFor := func(addr string) *http.ServeMux {
+		mux, ok := servers[addr]
+		if !ok {
+			mux = http.NewServeMux()
+			servers[addr] = mux
+		}
+
+		return mux
+	}
+
+	if cfg.MetricsAddr != "" {
+		serverFor(cfg.MetricsAddr).Handle("/metrics", metricsHandler(client))
 	}
 
-	currentAirTemp, err := client.GetAirTemperature()
-	if err != nil {
-		log.Debug.Fatal(err)
+	if cfg.HTTPAddr != "" {
+		serverFor(cfg.HTTPAddr).Handle("/", NewAPIHandler(client))
+		serverFor(cfg.HTTPAddr).Handle("/events", NewStatusStream(client, 5*time.Second))
+		serverFor(cfg.HTTPAddr).Handle("/dashboard/", dashboardHandler())
 	}
 
-	airTemp := accessory.NewTemperatureSensor(airTempInfo, currentAirTemp, -40, 150, 0.25)
+	for addr, mux := range servers {
+		go func(addr string, mux *http.ServeMux) {
+			log.Info.Fatal(http.ListenAndServe(addr, mux))
+		}(addr, mux)
+	}
+
+	if cfg.Gateway.ClockSync.Duration > 0 {
+		go clockSyncLoop(client, cfg.Gateway.ClockSync.Duration, cfg.Gateway.AdjustForDST)
+	}
 
-	pool := NewPoolAccessory(client)
+	var mqttBridge *MQTTBridge
 
-	spa := NewSpaAccessory(client)
+	if cfg.MQTT.Broker != "" {
+		mqttBridge = NewMQTTBridge(client, cfg.mqttOptions())
 
-	pwConfig := hc.Config{Pin: pinCode}
+		err = mqttBridge.Start()
+		if err != nil {
+			log.Debug.Fatal(err)
+		}
+	}
+
+	var t hc.Transport
+
+	if cfg.HomeKit.Enabled {
+		t, err = newHomeKitTransport(client, cfg)
+		if err != nil {
+			log.Debug.Panic(err)
+		}
+	}
+
+	done := make(chan struct{})
+
+	hc.OnTermination(func() {
+		if mqttBridge != nil {
+			mqttBridge.Stop()
+		}
+
+		if t != nil {
+			<-t.Stop()
+		}
+
+		close(done)
+	})
+
+	if t != nil {
+		if cfg.HomeKit.QRCode {
+			printSetupCode(t, cfg.HomeKit.Pin)
+		}
+
+		t.Start()
+	}
+
+	<-done
+}
+
+func newHomeKitTransport(client *Client, cfg *Config) (hc.Transport, error) {
+	manufacturer := cfg.HomeKit.Manufacturer
 
 	gatewayName := client.GetGatewayName()
 
@@ -52,29 +117,250 @@ func main() {
 	// Also call it ScreenLogic instead of the more generic Pentair name.
 	gatewayName = strings.Replace(gatewayName, "Pentair: ", "ScreenLogic-", 1)
 
+	// This is the same default hc uses, we just need to know it up front to keep accessory IDs there too.
+	storagePath := cfg.HomeKit.StoragePath
+	if storagePath == "" {
+		storagePath = gatewayName
+	}
+
+	ids, err := LoadAccessoryIDs(storagePath, client.GetGatewayMacAddr())
+	if err != nil {
+		return nil, err
+	}
+
+	var accessories []*accessory.Accessory
+
+	if cfg.Accessories.AirTemperature.Enabled {
+		airTempInfo := accessory.Info{
+			Manufacturer: manufacturer,
+			Model:        "ScreenLogic",
+			Name:         cfg.Accessories.AirTemperature.Name,
+		}
+
+		currentAirTemp, err := client.GetAirTemperature()
+		if err != nil {
+			return nil, err
+		}
+
+		airTemp := accessory.NewTemperatureSensor(airTempInfo, currentAirTemp, cfg.Accessories.AirTemperature.Min, cfg.Accessories.AirTemperature.Max, 0.25)
+		ids.Assign(airTemp.Accessory, "air_temperature")
+
+		addStatusFault(airTemp.TempSensor.Service, client)
+
+		accessories = append(accessories, airTemp.Accessory)
+	}
+
+	if cfg.Accessories.FreezeProtection.Enabled {
+		freeze, err := NewStatusSensorAccessory(client, cfg.Accessories.FreezeProtection.Name, manufacturer, client.GetFreezeProtectionDetected)
+		if err != nil {
+			return nil, err
+		}
+
+		ids.Assign(freeze.Accessory, "freeze_protection")
+
+		accessories = append(accessories, freeze.Accessory)
+	}
+
+	if cfg.Accessories.ServiceMode.Enabled {
+		serviceMode, err := NewStatusSensorAccessory(client, cfg.Accessories.ServiceMode.Name, manufacturer, client.GetServiceModeDetected)
+		if err != nil {
+			return nil, err
+		}
+
+		ids.Assign(serviceMode.Accessory, "service_mode")
+
+		accessories = append(accessories, serviceMode.Accessory)
+	}
+
+	if cfg.Accessories.Delay.Enabled {
+		delay, err := NewDelayAccessory(client, cfg.Accessories.Delay.Name, manufacturer)
+		if err != nil {
+			return nil, err
+		}
+
+		ids.Assign(delay.Accessory, "delay")
+
+		accessories = append(accessories, delay.Accessory)
+	}
+
+	controllerConfig, err := client.getControllerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only expose bodies of water the controller is set up with. Single body systems have
+	// no spa, and asking for its status would fail.
+	if cfg.Accessories.Pool.Enabled && controllerConfig.HasBody(screenlogic.BodyOfWaterPool) {
+		pool, err := NewPoolAccessory(client, cfg.Accessories.Pool.Name, manufacturer, cfg.HomeKit.SetPointDelay.Duration)
+		if err != nil {
+			return nil, err
+		}
+
+		ids.Assign(pool.Accessory, "pool")
+
+		accessories = append(accessories, pool.Accessory)
+	}
+
+	if cfg.Accessories.Spa.Enabled {
+		if controllerConfig.HasBody(screenlogic.BodyOfWaterSpa) {
+			spa, err := NewSpaAccessory(client, cfg.Accessories.Spa.Name, manufacturer, cfg.HomeKit.SetPointDelay.Duration)
+			if err != nil {
+				return nil, err
+			}
+
+			ids.Assign(spa.Accessory, "spa")
+
+			accessories = append(accessories, spa.Accessory)
+		} else {
+			log.Info.Println("homekit: controller has no spa, not exposing one")
+		}
+	}
+
+	// Chemistry readings are all zero without the equipment, which would look like an alarm or no salt.
+	if cfg.Accessories.ChemistryAlarm.Enabled {
+		if controllerConfig.HasIntellichem() {
+			chemistryAlarm, err := NewStatusSensorAccessory(client, cfg.Accessories.ChemistryAlarm.Name, manufacturer, client.GetChemistryAlarmDetected)
+			if err != nil {
+				return nil, err
+			}
+
+			ids.Assign(chemistryAlarm.Accessory, "chemistry_alarm")
+
+			accessories = append(accessories, chemistryAlarm.Accessory)
+		} else {
+			log.Info.Println("homekit: controller has no IntelliChem, not exposing a chemistry alarm")
+		}
+	}
+
+	if cfg.Accessories.LowSalt.Enabled {
+		if controllerConfig.HasChlorinator() {
+			minPPM := cfg.Accessories.LowSalt.MinPPM
+
+			lowSalt, err := NewStatusSensorAccessory(client, cfg.Accessories.LowSalt.Name, manufacturer, func() (int, error) {
+				return client.GetLowSaltDetected(minPPM)
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			ids.Assign(lowSalt.Accessory, "low_salt")
+
+			accessories = append(accessories, lowSalt.Accessory)
+		} else {
+			log.Info.Println("homekit: controller has no chlorinator, not exposing a low salt sensor")
+		}
+	}
+
+	for _, circuitCfg := range circuitAccessories(cfg, controllerConfig) {
+		circuit, err := NewCircuitAccessory(client, circuitCfg.ID, circuitCfg.Name, manufacturer)
+		if err != nil {
+			return nil, err
+		}
+
+		ids.AssignCircuit(circuit.Accessory, circuitCfg.ID)
+
+		accessories = append(accessories, circuit.Accessory)
+	}
+
+	pwConfig := hc.Config{
+		Pin:         cfg.HomeKit.Pin,
+		StoragePath: storagePath,
+		Port:        cfg.HomeKit.Port,
+		SetupId:     cfg.HomeKit.SetupID,
+		IP:          cfg.HomeKit.IP,
+	}
+
+	err = ids.Save()
+	if err != nil {
+		return nil, err
+	}
+
 	gatewayVersion, err := client.GetGatewayVersion()
 	if err != nil {
-		log.Debug.Fatal(err)
+		return nil, err
 	}
 
 	bridgeInfo := accessory.Info{
-		Manufacturer:     "Pentair",
+		Manufacturer:     manufacturer,
 		Model:            "ScreenLogic",
 		Name:             gatewayName,
 		FirmwareRevision: gatewayVersion,
 	}
 
 	bridge := accessory.NewBridge(bridgeInfo)
+	bridge.ID = bridgeAccessoryID
 
 	// NOTE: the first accessory in the list acts as the bridge, while the rest will be linked to it
-	t, err := hc.NewIPTransport(pwConfig, bridge.Accessory, airTemp.Accessory, pool.Accessory, spa.Accessory)
+	return hc.NewIPTransport(pwConfig, bridge.Accessory, accessories...)
+}
+
+// printSetupCode - prints a QR code that can be scanned from the Home app to pair the bridge,
+// along with the X-HM URI it encodes and the pin, for anyone who can't scan it.
+func printSetupCode(t hc.Transport, pin string) {
+	xhm, ok := t.(interface{ XHMURI() (string, error) })
+	if !ok {
+		return
+	}
+
+	uri, err := xhm.XHMURI()
 	if err != nil {
-		log.Debug.Panic(err)
+		log.Info.Printf("homekit: unable to build setup code: %v\n", err)
+		return
 	}
 
-	hc.OnTermination(func() {
-		<-t.Stop()
-	})
+	qrterminal.GenerateHalfBlock(uri, qrterminal.L, os.Stdout)
+
+	fmt.Printf("HomeKit setup URI: %s\nHomeKit pin: %s-%s-%s\n", uri, pin[:3], pin[3:5], pin[5:])
+}
+
+// circuitAccessories - which circuits to expose as switches, filled in with their names from the
+// controller. Circuits that aren't on the controller are skipped.
+func circuitAccessories(cfg *Config, controllerConfig *screenlogic.ControllerConfiguration) []CircuitConfig {
+	names := make(map[uint32]string, len(controllerConfig.Circuits))
+	for _, circuit := range controllerConfig.Circuits {
+		names[circuit.ID] = circuit.Name
+	}
+
+	wanted := cfg.Accessories.Circuits
+
+	if cfg.Accessories.AllCircuits {
+		configured := make(map[uint32]CircuitConfig, len(wanted))
+		for _, circuitCfg := range wanted {
+			configured[circuitCfg.ID] = circuitCfg
+		}
+
+		// Keep the controller's order, but any names from the config still win.
+		wanted = nil
+		for _, circuit := range controllerConfig.Circuits {
+			circuitCfg, ok := configured[circuit.ID]
+			if !ok {
+				// Circuits hidden from the ScreenLogic app are left out unless they're listed explicitly.
+				if circuit.Interface == protocol.CircuitInterfaceHidden || circuit.Interface == protocol.CircuitInterfaceInvalid {
+					continue
+				}
+
+				circuitCfg = CircuitConfig{ID: circuit.ID}
+			}
+
+			wanted = append(wanted, circuitCfg)
+		}
+	}
+
+	var circuits []CircuitConfig
+
+	for _, circuitCfg := range wanted {
+		name, ok := names[circuitCfg.ID]
+		if !ok {
+			log.Info.Printf("homekit: circuit %d is not configured on the controller, skipping it\n", circuitCfg.ID)
+			continue
+		}
+
+		if circuitCfg.Name == "" {
+			circuitCfg.Name = name
+		}
+
+		circuits = append(circuits, circuitCfg)
+	}
 
-	t.Start()
+	return circuits
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
+	"github.com/brutella/hc/log"
+)
+
+// Upper bounds (in seconds) of the request latency histogram buckets. The gateway usually
+// answers in well under 100ms, but history requests can take several seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // one per entry in latencyBuckets, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+
+	h.count++
+	h.sum += seconds
+}
+
+// clientMetrics - counters for the requests Client makes to the gateway.
+type clientMetrics struct {
+	mutex      sync.Mutex
+	requests   map[string]uint64
+	errors     map[string]uint64
+	latency    map[string]*histogram
+	reconnects uint64
+}
+
+func newClientMetrics() *clientMetrics {
+	return &clientMetrics{
+		requests: make(map[string]uint64),
+		errors:   make(map[string]uint64),
+		latency:  make(map[string]*histogram),
+	}
+}
+
+func (cm *clientMetrics) observeRequest(op string, duration time.Duration, err error) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cm.requests[op]++
+
+	if err != nil {
+		cm.errors[op]++
+	}
+
+	h, ok := cm.latency[op]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		cm.latency[op] = h
+	}
+
+	h.observe(duration.Seconds())
+}
+
+func (cm *clientMetrics) observeReconnect() {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cm.reconnects++
+}
+
+// metricsWriter - writes metrics in the Prometheus text exposition format.
+// See https://prometheus.io/docs/instrumenting/exposition_formats/
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (mw *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (mw *metricsWriter) sample(name string, labels map[string]string, value float64) {
+	mw.w.WriteString(name)
+
+	if len(labels) > 0 {
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		pairs := make([]string, len(keys))
+		for i, k := range keys {
+			pairs[i] = fmt.Sprintf("%s=%q", k, labels[k])
+		}
+
+		fmt.Fprintf(mw.w, "{%s}", strings.Join(pairs, ","))
+	}
+
+	fmt.Fprintf(mw.w, " %g\n", value)
+}
+
+func (mw *metricsWriter) gauge(name, help string, value float64) {
+	mw.header(name, "gauge", help)
+	mw.sample(name, nil, value)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (cm *clientMetrics) write(mw *metricsWriter) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	ops := make([]string, 0, len(cm.requests))
+	for op := range cm.requests {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	mw.header("screenlogic_gateway_requests_total", "counter", "Requests made to the gateway.")
+	for _, op := range ops {
+		mw.sample("screenlogic_gateway_requests_total", map[string]string{"op": op}, float64(cm.requests[op]))
+	}
+
+	mw.header("screenlogic_gateway_errors_total", "counter", "Requests to the gateway that failed.")
+	for _, op := range ops {
+		mw.sample("screenlogic_gateway_errors_total", map[string]string{"op": op}, float64(cm.errors[op]))
+	}
+
+	mw.header("screenlogic_gateway_reconnects_total", "counter", "Times the connection to the gateway was re-established.")
+	mw.sample("screenlogic_gateway_reconnects_total", nil, float64(cm.reconnects))
+
+	mw.header("screenlogic_gateway_request_duration_seconds", "histogram", "Round-trip time of requests to the gateway.")
+	for _, op := range ops {
+		h := cm.latency[op]
+
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			mw.sample("screenlogic_gateway_request_duration_seconds_bucket", map[string]string{"op": op, "le": fmt.Sprintf("%g", bound)}, float64(cumulative))
+		}
+
+		mw.sample("screenlogic_gateway_request_duration_seconds_bucket", map[string]string{"op": op, "le": "+Inf"}, float64(h.count))
+		mw.sample("screenlogic_gateway_request_duration_seconds_sum", map[string]string{"op": op}, h.sum)
+		mw.sample("screenlogic_gateway_request_duration_seconds_count", map[string]string{"op": op}, float64(h.count))
+	}
+}
+
+// writePoolMetrics - writes gauges for everything we know about the pool from the latest status.
+// All temperatures are exported in celsius, regardless of how the controller is configured.
+func writePoolMetrics(mw *metricsWriter, config *screenlogic.ControllerConfiguration, status *screenlogic.PoolStatus) {
+	toCelsius := func(temp uint32) float64 {
+		return screenlogic.ConvertTemperature(float64(temp), config.Units(), screenlogic.Celsius)
+	}
+
+	mw.gauge("screenlogic_controller_state", "Raw controller state: 1 ready, 2 syncing, 3 service mode.", float64(status.OK))
+	mw.gauge("screenlogic_controller_ready", "Whether the controller reports it is ready.", boolToFloat(status.IsReady()))
+	mw.gauge("screenlogic_freeze_mode", "Whether freeze protection is active.", boolToFloat(status.FreezeMode != 0))
+	mw.gauge("screenlogic_air_temperature_celsius", "Ambient air temperature.", toCelsius(status.AirTemp))
+
+	bodies := map[string]int{"pool": int(screenlogic.BodyOfWaterPool), "spa": int(screenlogic.BodyOfWaterSpa)}
+	names := []string{"pool", "spa"}
+
+	mw.header("screenlogic_water_temperature_celsius", "gauge", "Current water temperature.")
+	for _, name := range names {
+		if idx := bodies[name]; idx < len(status.Bodies) {
+			mw.sample("screenlogic_water_temperature_celsius", map[string]string{"body": name}, toCelsius(status.Bodies[idx].CurrentTemp))
+		}
+	}
+
+	mw.header("screenlogic_heat_set_point_celsius", "gauge", "Heat set point.")
+	for _, name := range names {
+		if idx := bodies[name]; idx < len(status.Bodies) {
+			mw.sample("screenlogic_heat_set_point_celsius", map[string]string{"body": name}, toCelsius(status.Bodies[idx].HeatSetPoint))
+		}
+	}
+
+	mw.header("screenlogic_heater_on", "gauge", "Whether the heater is currently running for this body of water.")
+	for _, name := range names {
+		if idx := bodies[name]; idx < len(status.Bodies) {
+			mw.sample("screenlogic_heater_on", map[string]string{"body": name}, boolToFloat(status.Bodies[idx].HeaterStatus != 0))
+		}
+	}
+
+	modes := []screenlogic.HeatMode{
+		screenlogic.HeatModeOff,
+		screenlogic.HeatModeSolarOnly,
+		screenlogic.HeatModeSolarPreferred,
+		screenlogic.HeatModeOn,
+	}
+
+	mw.header("screenlogic_heat_mode", "gauge", "Selected heat mode, 1 for the active mode and 0 for the rest.")
+	for _, name := range names {
+		if idx := bodies[name]; idx < len(status.Bodies) {
+			for _, mode := range modes {
+				active := screenlogic.HeatMode(status.Bodies[idx].HeatMode) == mode
+				mw.sample("screenlogic_heat_mode", map[string]string{"body": name, "mode": mode.String()}, boolToFloat(active))
+			}
+		}
+	}
+
+	circuitNames := make(map[uint32]string, len(config.Circuits))
+	for _, circuit := range config.Circuits {
+		circuitNames[circuit.ID] = circuit.Name
+	}
+
+	mw.header("screenlogic_circuit_on", "gauge", "Whether a circuit is currently on.")
+	for _, circuit := range status.Circuits {
+		labels := map[string]string{"id": fmt.Sprintf("%d", circuit.ID), "name": circuitNames[circuit.ID]}
+		mw.sample("screenlogic_circuit_on", labels, boolToFloat(circuit.ValveState != 0))
+	}
+
+	// Without the equipment these are always zero, which looks like a real reading on a graph.
+	if config.HasIntellichem() {
+		mw.gauge("screenlogic_ph", "pH reported by the chemistry controller.", float64(status.Chemistry.PH))
+		mw.gauge("screenlogic_orp_millivolts", "ORP reported by the chemistry controller.", float64(status.Chemistry.ORP))
+		mw.gauge("screenlogic_saturation_index", "Saturation index reported by the chemistry controller.", float64(status.Chemistry.Saturation))
+	}
+
+	if config.HasChlorinator() {
+		mw.gauge("screenlogic_salt_ppm", "Salt level reported by the chlorinator.", float64(status.Chemistry.SaltPPM))
+	}
+}
+
+// metricsHandler - serves /metrics. Pool status comes from the client's cache, so scraping
+// doesn't put any more load on the gateway than HomeKit already does.
+func metricsHandler(client *Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config, err := client.getControllerConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		status, err := client.getPoolStatus()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		mw := &metricsWriter{w: bufio.NewWriter(w)}
+
+		writePoolMetrics(mw, config, status)
+		client.metrics.write(mw)
+
+		health := client.GetConnectionHealth()
+		mw.gauge("screenlogic_gateway_rtt_seconds", "How long the last reply from the gateway took.", health.RTT.Seconds())
+
+		// Until the first reply, there's no time to report.
+		if !health.LastRoundTrip.IsZero() {
+			mw.gauge("screenlogic_gateway_last_round_trip_timestamp_seconds", "When the gateway last replied to anything, including keepalive pings.", float64(health.LastRoundTrip.UnixNano())/1e9)
+		}
+
+		err = mw.w.Flush()
+		if err != nil {
+			log.Info.Printf("metrics: %v\n", err)
+		}
+	})
+}
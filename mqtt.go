@@ -0,0 +1,572 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+	"github.com/brutella/hc/log"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type MQTTOptions struct {
+	Broker          string // e.g. tcp://localhost:1883
+	Username        string
+	Password        string
+	BaseTopic       string
+	DiscoveryPrefix string // Home Assistant discovery prefix, discovery is disabled if empty
+	Interval        time.Duration
+}
+
+// MQTTBridge - publishes pool state to an MQTT broker and listens for commands, so the pool
+// can be used from things like Home Assistant.
+//
+// State topics (all relative to BaseTopic):
+//
+//	availability                online|offline
+//	status                      PoolStatus as JSON
+//	config                      ControllerConfiguration as JSON
+//	air/temperature             in the controller's units
+//	<pool|spa>/temperature      in the controller's units
+//	<pool|spa>/setpoint         in the controller's units
+//	<pool|spa>/heat_mode        off|solar|solar-preferred|on
+//	<pool|spa>/mode             off|heat (Home Assistant climate mode)
+//	<pool|spa>/action           off|idle|heating (Home Assistant climate action)
+//	circuit/<id>/state          ON|OFF
+//
+// Command topics are the matching state topic with /set appended, for setpoint, heat_mode, mode
+// and circuit/<id>. They aren't subscribed to when the client is read-only.
+type MQTTBridge struct {
+	client  *Client
+	options MQTTOptions
+	mqtt    mqtt.Client
+	nodeID  string
+	done    chan struct{}
+}
+
+func NewMQTTBridge(client *Client, options MQTTOptions) *MQTTBridge {
+	if options.BaseTopic == "" {
+		options.BaseTopic = "screenlogic"
+	}
+
+	if options.Interval <= 0 {
+		options.Interval = 30 * time.Second
+	}
+
+	// Home Assistant object IDs may only contain [a-zA-Z0-9_-]
+	nodeID := strings.ToLower(strings.NewReplacer("-", "", ":", "").Replace(client.GetGatewayMacAddr()))
+	if nodeID == "" {
+		nodeID = "screenlogic"
+	}
+
+	mb := &MQTTBridge{
+		client:  client,
+		options: options,
+		nodeID:  nodeID,
+		done:    make(chan struct{}),
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(options.Broker)
+	opts.SetClientID("screenlogic-homekit-" + nodeID)
+	opts.SetUsername(options.Username)
+	opts.SetPassword(options.Password)
+	opts.SetAutoReconnect(true)
+	opts.SetWill(mb.topic("availability"), "offline", 1, true)
+	opts.SetOnConnectHandler(mb.onConnect)
+
+	mb.mqtt = mqtt.NewClient(opts)
+
+	return mb
+}
+
+func (mb *MQTTBridge) topic(parts ...string) string {
+	return mb.options.BaseTopic + "/" + strings.Join(parts, "/")
+}
+
+func (mb *MQTTBridge) Start() error {
+	token := mb.mqtt.Connect()
+	token.Wait()
+
+	err := token.Error()
+	if err != nil {
+		return err
+	}
+
+	go mb.publishLoop()
+
+	return nil
+}
+
+func (mb *MQTTBridge) Stop() {
+	close(mb.done)
+
+	token := mb.mqtt.Publish(mb.topic("availability"), 1, true, "offline")
+	token.WaitTimeout(time.Second)
+
+	mb.mqtt.Disconnect(250)
+}
+
+// onConnect - called on the initial connection, as well as every time the mqtt client reconnects.
+// Subscriptions aren't guaranteed to survive a reconnect, so they're (re-)established here.
+func (mb *MQTTBridge) onConnect(c mqtt.Client) {
+	if !mb.client.readOnly {
+		mb.subscribeCommands(c)
+	}
+
+	if mb.options.DiscoveryPrefix != "" {
+		err := mb.publishDiscovery()
+		if err != nil {
+			log.Info.Printf("mqtt: unable to publish discovery: %v\n", err)
+		}
+	}
+
+	// The broker publishes our will, "offline", whenever the connection drops, so availability always
+	// has to be published again here.
+	mb.refresh()
+}
+
+// subscribeCommands - listens on every command topic. This isn't done at all when the client is read-only,
+// so nothing on the broker can even try to change the pool.
+func (mb *MQTTBridge) subscribeCommands(c mqtt.Client) {
+	subscriptions := map[string]mqtt.MessageHandler{
+		mb.topic("+", "setpoint", "set"):  mb.handleSetPoint,
+		mb.topic("+", "heat_mode", "set"): mb.handleHeatMode,
+		mb.topic("+", "mode", "set"):      mb.handleMode,
+		mb.topic("circuit", "+", "set"):   mb.handleCircuit,
+	}
+
+	for topic, handler := range subscriptions {
+		token := c.Subscribe(topic, 1, handler)
+		token.Wait()
+
+		err := token.Error()
+		if err != nil {
+			log.Info.Printf("mqtt: unable to subscribe to %s: %v\n", topic, err)
+		}
+	}
+}
+
+func (mb *MQTTBridge) publishLoop() {
+	ticker := time.NewTicker(mb.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mb.done:
+			return
+		case <-ticker.C:
+			mb.refresh()
+		}
+	}
+}
+
+// refresh - publishes the current state, and whether we could get it from the gateway to availability,
+// so Home Assistant shows everything as unavailable instead of stale while the gateway can't be reached.
+func (mb *MQTTBridge) refresh() {
+	err := mb.publishState()
+	if err != nil {
+		log.Info.Printf("mqtt: unable to publish state: %v\n", err)
+
+		mb.publishAvailability(false)
+
+		return
+	}
+
+	mb.publishAvailability(true)
+}
+
+func (mb *MQTTBridge) publishAvailability(online bool) {
+	payload := "offline"
+	if online {
+		payload = "online"
+	}
+
+	mb.mqtt.Publish(mb.topic("availability"), 1, true, payload)
+}
+
+func (mb *MQTTBridge) publish(topic string, retained bool, payload interface{}) {
+	mb.mqtt.Publish(topic, 0, retained, payload)
+}
+
+func (mb *MQTTBridge) publishJSON(topic string, retained bool, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	mb.publish(topic, retained, payload)
+
+	return nil
+}
+
+func (mb *MQTTBridge) publishState() error {
+	config, err := mb.client.getControllerConfig()
+	if err != nil {
+		return err
+	}
+
+	status, err := mb.client.getPoolStatus()
+	if err != nil {
+		return err
+	}
+
+	err = mb.publishJSON(mb.topic("config"), true, config)
+	if err != nil {
+		return err
+	}
+
+	err = mb.publishJSON(mb.topic("status"), true, status)
+	if err != nil {
+		return err
+	}
+
+	mb.publish(mb.topic("air", "temperature"), true, strconv.Itoa(int(status.AirTemp)))
+
+	for _, name := range []string{"pool", "spa"} {
+		body := bodyOfWater(status, name)
+		if body == nil {
+			continue
+		}
+
+		heatMode := screenlogic.HeatMode(body.HeatMode)
+
+		mode := "heat"
+		action := "idle"
+
+		if heatMode == screenlogic.HeatModeOff {
+			mode = "off"
+			action = "off"
+		} else if body.HeaterStatus != 0 {
+			action = "heating"
+		}
+
+		mb.publish(mb.topic(name, "temperature"), true, strconv.Itoa(int(body.CurrentTemp)))
+		mb.publish(mb.topic(name, "setpoint"), true, strconv.Itoa(int(body.HeatSetPoint)))
+		mb.publish(mb.topic(name, "heat_mode"), true, heatMode.String())
+		mb.publish(mb.topic(name, "mode"), true, mode)
+		mb.publish(mb.topic(name, "action"), true, action)
+	}
+
+	for _, circuit := range status.Circuits {
+		state := "OFF"
+		if circuit.ValveState != 0 {
+			state = "ON"
+		}
+
+		mb.publish(mb.topic("circuit", strconv.Itoa(int(circuit.ID)), "state"), true, state)
+	}
+
+	return nil
+}
+
+// bodyOfWater - returns the status for the named body of water, or nil if this system doesn't have it.
+func bodyOfWater(status *screenlogic.PoolStatus, name string) *protocol.BodyOfWater {
+	switch name {
+	case "pool":
+		return status.PoolWater()
+	case "spa":
+		return status.SpaWater()
+	default:
+		return nil
+	}
+}
+
+// topicBody - returns which body of water a command topic like screenlogic/spa/setpoint/set is for.
+func (mb *MQTTBridge) topicBody(topic string) (screenlogic.BodyOfWater, error) {
+	parts := strings.Split(strings.TrimPrefix(topic, mb.options.BaseTopic+"/"), "/")
+
+	switch parts[0] {
+	case "pool":
+		return screenlogic.BodyOfWaterPool, nil
+	case "spa":
+		return screenlogic.BodyOfWaterSpa, nil
+	default:
+		return 0, fmt.Errorf("unknown body of water %q", parts[0])
+	}
+}
+
+func (mb *MQTTBridge) handleSetPoint(c mqtt.Client, msg mqtt.Message) {
+	body, err := mb.topicBody(msg.Topic())
+	if err != nil {
+		log.Info.Printf("mqtt: %s: %v\n", msg.Topic(), err)
+		return
+	}
+
+	// Home Assistant sends set points as floats, like "84.0"
+	temp, err := strconv.ParseFloat(string(msg.Payload()), 64)
+	if err != nil || math.IsNaN(temp) || math.IsInf(temp, 0) || temp < 0 {
+		log.Info.Printf("mqtt: %s: invalid set point %q\n", msg.Topic(), msg.Payload())
+		return
+	}
+
+	min, max, err := mb.client.getSetPointRange(body)
+	if err != nil {
+		mb.afterCommand(msg.Topic(), err)
+		return
+	}
+
+	// Checked before rounding, so nothing too big for a uint32 gets converted.
+	temp = math.Round(temp)
+	if temp < float64(min) || temp > float64(max) {
+		log.Info.Printf("mqtt: %s: set point %q is outside of %d-%d\n", msg.Topic(), msg.Payload(), min, max)
+		return
+	}
+
+	err = mb.client.SetTemperature(body, uint32(temp))
+	mb.afterCommand(msg.Topic(), err)
+}
+
+func (mb *MQTTBridge) handleHeatMode(c mqtt.Client, msg mqtt.Message) {
+	body, err := mb.topicBody(msg.Topic())
+	if err != nil {
+		log.Info.Printf("mqtt: %s: %v\n", msg.Topic(), err)
+		return
+	}
+
+	mode, err := screenlogic.ParseHeatMode(string(msg.Payload()))
+	if err != nil {
+		log.Info.Printf("mqtt: %s: %v\n", msg.Topic(), err)
+		return
+	}
+
+	err = mb.client.SetHeatMode(body, mode)
+	mb.afterCommand(msg.Topic(), err)
+}
+
+func (mb *MQTTBridge) handleMode(c mqtt.Client, msg mqtt.Message) {
+	body, err := mb.topicBody(msg.Topic())
+	if err != nil {
+		log.Info.Printf("mqtt: %s: %v\n", msg.Topic(), err)
+		return
+	}
+
+	switch string(msg.Payload()) {
+	case "off":
+		err = mb.client.SetHeatMode(body, screenlogic.HeatModeOff)
+	case "heat":
+		err = mb.client.SetHeatMode(body, screenlogic.HeatModeOn)
+	default:
+		log.Info.Printf("mqtt: %s: invalid mode %q\n", msg.Topic(), msg.Payload())
+		return
+	}
+
+	mb.afterCommand(msg.Topic(), err)
+}
+
+func (mb *MQTTBridge) handleCircuit(c mqtt.Client, msg mqtt.Message) {
+	parts := strings.Split(msg.Topic(), "/")
+
+	// .../circuit/<id>/set
+	circuitID, err := strconv.ParseUint(parts[len(parts)-2], 10, 32)
+	if err != nil {
+		log.Info.Printf("mqtt: %s: invalid circuit id\n", msg.Topic())
+		return
+	}
+
+	switch strings.ToUpper(string(msg.Payload())) {
+	case "ON":
+		err = mb.client.SetCircuitState(uint32(circuitID), true)
+	case "OFF":
+		err = mb.client.SetCircuitState(uint32(circuitID), false)
+	default:
+		log.Info.Printf("mqtt: %s: invalid circuit state %q\n", msg.Topic(), msg.Payload())
+		return
+	}
+
+	mb.afterCommand(msg.Topic(), err)
+}
+
+// afterCommand - logs any error from a command, and otherwise publishes the new state right away
+// so whoever sent the command sees it take effect. A command that failed because the gateway can't
+// be reached marks everything unavailable, until the next refresh finds it again.
+func (mb *MQTTBridge) afterCommand(topic string, err error) {
+	if err != nil {
+		log.Info.Printf("mqtt: %s: %v\n", topic, err)
+
+		if isConnectionError(err) {
+			mb.publishAvailability(false)
+		}
+
+		return
+	}
+
+	mb.refresh()
+}
+
+// publishDiscovery - publishes Home Assistant MQTT discovery payloads for everything we expose.
+// See https://www.home-assistant.io/docs/mqtt/discovery/
+//
+// When the client is read-only, bodies of water and circuits are published as sensors instead of
+// things that can be controlled. Whichever kind isn't in use is removed, in case it was published
+// before the read-only setting changed.
+func (mb *MQTTBridge) publishDiscovery() error {
+	config, err := mb.client.getControllerConfig()
+	if err != nil {
+		return err
+	}
+
+	version, err := mb.client.GetGatewayVersion()
+	if err != nil {
+		return err
+	}
+
+	device := map[string]interface{}{
+		"identifiers":  []string{mb.nodeID},
+		"name":         mb.client.GetGatewayName(),
+		"manufacturer": "Pentair",
+		"model":        "ScreenLogic",
+		"sw_version":   version,
+	}
+
+	availability := mb.topic("availability")
+	units := string(config.Units())
+
+	discoveryTopic := func(component, objectID string) string {
+		return strings.Join([]string{mb.options.DiscoveryPrefix, component, mb.nodeID, objectID, "config"}, "/")
+	}
+
+	err = mb.publishJSON(discoveryTopic("sensor", "air_temperature"), true, map[string]interface{}{
+		"name":                "Ambient Air Temperature",
+		"unique_id":           mb.nodeID + "_air_temperature",
+		"device":              device,
+		"availability_topic":  availability,
+		"device_class":        "temperature",
+		"unit_of_measurement": "°" + units,
+		"state_topic":         mb.topic("air", "temperature"),
+	})
+	if err != nil {
+		return err
+	}
+
+	bodies := []struct {
+		name  string
+		label string
+		body  screenlogic.BodyOfWater
+	}{
+		{"pool", "Pool", screenlogic.BodyOfWaterPool},
+		{"spa", "Hot Tub", screenlogic.BodyOfWaterSpa},
+	}
+
+	for _, b := range bodies {
+		// Decided from the controller's configuration, the same as HomeKit, rather than from whichever bodies
+		// the status happens to include. Anything published for a body it doesn't have is removed.
+		if !config.HasBody(b.body) {
+			mb.removeDiscovery(discoveryTopic("climate", b.name))
+			mb.removeDiscovery(discoveryTopic("sensor", b.name+"_temperature"))
+			mb.removeDiscovery(discoveryTopic("sensor", b.name+"_setpoint"))
+
+			continue
+		}
+
+		if mb.client.readOnly {
+			mb.removeDiscovery(discoveryTopic("climate", b.name))
+
+			err = mb.publishJSON(discoveryTopic("sensor", b.name+"_temperature"), true, map[string]interface{}{
+				"name":                b.label + " Temperature",
+				"unique_id":           mb.nodeID + "_" + b.name + "_temperature",
+				"device":              device,
+				"availability_topic":  availability,
+				"device_class":        "temperature",
+				"unit_of_measurement": "°" + units,
+				"state_topic":         mb.topic(b.name, "temperature"),
+			})
+			if err != nil {
+				return err
+			}
+
+			err = mb.publishJSON(discoveryTopic("sensor", b.name+"_setpoint"), true, map[string]interface{}{
+				"name":                b.label + " Set Point",
+				"unique_id":           mb.nodeID + "_" + b.name + "_setpoint",
+				"device":              device,
+				"availability_topic":  availability,
+				"device_class":        "temperature",
+				"unit_of_measurement": "°" + units,
+				"state_topic":         mb.topic(b.name, "setpoint"),
+			})
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		mb.removeDiscovery(discoveryTopic("sensor", b.name+"_temperature"))
+		mb.removeDiscovery(discoveryTopic("sensor", b.name+"_setpoint"))
+
+		minSetPoint, maxSetPoint, err := mb.client.getSetPointRange(b.body)
+		if err != nil {
+			return err
+		}
+
+		err = mb.publishJSON(discoveryTopic("climate", b.name), true, map[string]interface{}{
+			"name":                      b.label,
+			"unique_id":                 mb.nodeID + "_" + b.name,
+			"device":                    device,
+			"availability_topic":        availability,
+			"modes":                     []string{"off", "heat"},
+			"mode_state_topic":          mb.topic(b.name, "mode"),
+			"mode_command_topic":        mb.topic(b.name, "mode", "set"),
+			"action_topic":              mb.topic(b.name, "action"),
+			"current_temperature_topic": mb.topic(b.name, "temperature"),
+			"temperature_state_topic":   mb.topic(b.name, "setpoint"),
+			"temperature_command_topic": mb.topic(b.name, "setpoint", "set"),
+			"temperature_unit":          units,
+			"min_temp":                  minSetPoint,
+			"max_temp":                  maxSetPoint,
+			"precision":                 1.0,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, circuit := range config.Circuits {
+		id := strconv.Itoa(int(circuit.ID))
+
+		if mb.client.readOnly {
+			mb.removeDiscovery(discoveryTopic("switch", "circuit_"+id))
+
+			err = mb.publishJSON(discoveryTopic("binary_sensor", "circuit_"+id), true, map[string]interface{}{
+				"name":               circuit.Name,
+				"unique_id":          mb.nodeID + "_circuit_" + id,
+				"device":             device,
+				"availability_topic": availability,
+				"state_topic":        mb.topic("circuit", id, "state"),
+				"payload_on":         "ON",
+				"payload_off":        "OFF",
+			})
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		mb.removeDiscovery(discoveryTopic("binary_sensor", "circuit_"+id))
+
+		err = mb.publishJSON(discoveryTopic("switch", "circuit_"+id), true, map[string]interface{}{
+			"name":               circuit.Name,
+			"unique_id":          mb.nodeID + "_circuit_" + id,
+			"device":             device,
+			"availability_topic": availability,
+			"state_topic":        mb.topic("circuit", id, "state"),
+			"command_topic":      mb.topic("circuit", id, "set"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeDiscovery - an empty retained payload makes Home Assistant forget whatever was published to topic.
+func (mb *MQTTBridge) removeDiscovery(topic string) {
+	mb.publish(topic, true, "")
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeMQTT - stands in for the broker connection, keeping the last payload published to each topic and
+// the handler subscribed to each topic filter.
+type fakeMQTT struct {
+	mqtt.Client
+
+	mutex         sync.Mutex
+	published     map[string]string
+	subscriptions map[string]mqtt.MessageHandler
+}
+
+func newFakeMQTT() *fakeMQTT {
+	return &fakeMQTT{
+		published:     make(map[string]string),
+		subscriptions: make(map[string]mqtt.MessageHandler),
+	}
+}
+
+func (fm *fakeMQTT) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	switch p := payload.(type) {
+	case string:
+		fm.published[topic] = p
+	case []byte:
+		fm.published[topic] = string(p)
+	}
+
+	return doneToken{}
+}
+
+func (fm *fakeMQTT) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	fm.subscriptions[topic] = callback
+
+	return doneToken{}
+}
+
+func (fm *fakeMQTT) payload(topic string) (string, bool) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	payload, ok := fm.published[topic]
+
+	return payload, ok
+}
+
+func (fm *fakeMQTT) subscribed() []string {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	var topics []string
+	for topic := range fm.subscriptions {
+		topics = append(topics, topic)
+	}
+
+	sort.Strings(topics)
+
+	return topics
+}
+
+// send - delivers payload on topic to the handler subscribed with filter, like the broker would.
+func (fm *fakeMQTT) send(t *testing.T, filter, topic, payload string) {
+	t.Helper()
+
+	fm.mutex.Lock()
+	handler := fm.subscriptions[filter]
+	fm.mutex.Unlock()
+
+	if handler == nil {
+		t.Fatalf("nothing subscribed to %s", filter)
+	}
+
+	handler(fm, fakeMessage{topic: topic, payload: []byte(payload)})
+}
+
+type doneToken struct{}
+
+func (dt doneToken) Wait() bool                       { return true }
+func (dt doneToken) WaitTimeout(d time.Duration) bool { return true }
+func (dt doneToken) Done() <-chan struct{}            { return closedChan }
+func (dt doneToken) Error() error                     { return nil }
+
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+type fakeMessage struct {
+	mqtt.Message
+
+	topic   string
+	payload []byte
+}
+
+func (fm fakeMessage) Topic() string   { return fm.topic }
+func (fm fakeMessage) Payload() []byte { return fm.payload }
+
+func newTestBridge(t *testing.T, fg *fakeGateway, options ClientOptions) (*MQTTBridge, *fakeMQTT) {
+	client := fg.newClient(options)
+
+	mb := NewMQTTBridge(client, MQTTOptions{Broker: "tcp://127.0.0.1:1", DiscoveryPrefix: "homeassistant"})
+
+	fm := newFakeMQTT()
+	mb.mqtt = fm
+
+	mb.onConnect(fm)
+
+	return mb, fm
+}
+
+func TestMQTTPublishesState(t *testing.T) {
+	_, fm := newTestBridge(t, newFakePool(t), ClientOptions{})
+
+	want := map[string]string{
+		"screenlogic/availability":      "online",
+		"screenlogic/air/temperature":   "75",
+		"screenlogic/pool/temperature":  "82",
+		"screenlogic/pool/setpoint":     "84",
+		"screenlogic/pool/heat_mode":    "on",
+		"screenlogic/pool/mode":         "heat",
+		"screenlogic/pool/action":       "idle",
+		"screenlogic/spa/setpoint":      "102",
+		"screenlogic/spa/mode":          "off",
+		"screenlogic/circuit/505/state": "ON",
+		"screenlogic/circuit/500/state": "OFF",
+	}
+
+	for topic, payload := range want {
+		got, _ := fm.payload(topic)
+		if got != payload {
+			t.Errorf("%s is %q, want %q", topic, got, payload)
+		}
+	}
+}
+
+func TestMQTTPublishesDiscovery(t *testing.T) {
+	_, fm := newTestBridge(t, newFakePool(t), ClientOptions{})
+
+	payload, ok := fm.payload("homeassistant/climate/001122334455/pool/config")
+	if !ok {
+		t.Fatal("no climate discovery for the pool")
+	}
+
+	var climate map[string]interface{}
+
+	err := json.Unmarshal([]byte(payload), &climate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if climate["temperature_command_topic"] != "screenlogic/pool/setpoint/set" || climate["min_temp"] != 40.0 || climate["max_temp"] != 104.0 {
+		t.Errorf("unexpected pool discovery: %s", payload)
+	}
+
+	_, ok = fm.payload("homeassistant/switch/001122334455/circuit_501/config")
+	if !ok {
+		t.Error("no switch discovery for the pool light")
+	}
+}
+
+func TestMQTTCommands(t *testing.T) {
+	fg := newFakePool(t)
+	_, fm := newTestBridge(t, fg, ClientOptions{})
+
+	want := []string{
+		"screenlogic/+/heat_mode/set",
+		"screenlogic/+/mode/set",
+		"screenlogic/+/setpoint/set",
+		"screenlogic/circuit/+/set",
+	}
+
+	if got := fm.subscribed(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("subscribed to %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		filter   string
+		topic    string
+		payload  string
+		typeCode uint16
+		want     []uint32
+	}{
+		{"screenlogic/+/setpoint/set", "screenlogic/spa/setpoint/set", "101.6", protocol.SetHeatPointPacketCode, []uint32{0, 1, 102}},
+		{"screenlogic/+/heat_mode/set", "screenlogic/pool/heat_mode/set", "solar", protocol.SetHeatModePacketCode, []uint32{0, 0, 1}},
+		{"screenlogic/+/mode/set", "screenlogic/spa/mode/set", "heat", protocol.SetHeatModePacketCode, []uint32{0, 1, 3}},
+		{"screenlogic/circuit/+/set", "screenlogic/circuit/501/set", "ON", protocol.SetCircuitStatePacketCode, []uint32{0, 501, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			fm.send(t, tt.filter, tt.topic, tt.payload)
+
+			if got := fg.lastRequest(tt.typeCode); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gateway got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMQTTIgnoresInvalidCommands(t *testing.T) {
+	fg := newFakePool(t)
+	_, fm := newTestBridge(t, fg, ClientOptions{})
+
+	fm.send(t, "screenlogic/+/setpoint/set", "screenlogic/hot_tub/setpoint/set", "100")
+	fm.send(t, "screenlogic/+/setpoint/set", "screenlogic/spa/setpoint/set", "warm")
+	fm.send(t, "screenlogic/+/setpoint/set", "screenlogic/spa/setpoint/set", "NaN")
+	fm.send(t, "screenlogic/+/setpoint/set", "screenlogic/spa/setpoint/set", "+Inf")
+	fm.send(t, "screenlogic/+/setpoint/set", "screenlogic/spa/setpoint/set", "-5")
+	fm.send(t, "screenlogic/+/setpoint/set", "screenlogic/spa/setpoint/set", "1e20")
+	fm.send(t, "screenlogic/+/setpoint/set", "screenlogic/spa/setpoint/set", "110")
+	fm.send(t, "screenlogic/+/setpoint/set", "screenlogic/pool/setpoint/set", "39")
+	fm.send(t, "screenlogic/circuit/+/set", "screenlogic/circuit/501/set", "dim")
+
+	if len(fg.received(protocol.SetHeatPointPacketCode)) > 0 || len(fg.received(protocol.SetCircuitStatePacketCode)) > 0 {
+		t.Error("invalid commands were sent to the gateway")
+	}
+}
+
+func TestMQTTReadOnly(t *testing.T) {
+	_, fm := newTestBridge(t, newFakePool(t), ClientOptions{ReadOnly: true})
+
+	if got := fm.subscribed(); len(got) > 0 {
+		t.Errorf("subscribed to %v while read-only", got)
+	}
+
+	for _, topic := range []string{
+		"homeassistant/sensor/001122334455/pool_temperature/config",
+		"homeassistant/sensor/001122334455/spa_setpoint/config",
+		"homeassistant/binary_sensor/001122334455/circuit_501/config",
+	} {
+		payload, _ := fm.payload(topic)
+		if payload == "" {
+			t.Errorf("nothing published to %s", topic)
+		}
+	}
+
+	// Anything controllable from before read-only was turned on is removed.
+	for _, topic := range []string{
+		"homeassistant/climate/001122334455/pool/config",
+		"homeassistant/switch/001122334455/circuit_501/config",
+	} {
+		payload, ok := fm.payload(topic)
+		if !ok || payload != "" {
+			t.Errorf("%s wasn't removed, it's %q", topic, payload)
+		}
+	}
+}
+
+func TestMQTTGatewayUnreachable(t *testing.T) {
+	fg := newFakePool(t)
+	mb, fm := newTestBridge(t, fg, ClientOptions{ReconnectRetries: 1, CacheExpiry: time.Nanosecond})
+
+	fg.shutdown()
+
+	fm.send(t, "screenlogic/circuit/+/set", "screenlogic/circuit/501/set", "ON")
+
+	if got, _ := fm.payload("screenlogic/availability"); got != "offline" {
+		t.Errorf("availability is %q after a failed command, want %q", got, "offline")
+	}
+
+	fm.mutex.Lock()
+	fm.published["screenlogic/availability"] = "online"
+	fm.mutex.Unlock()
+
+	mb.refresh()
+
+	if got, _ := fm.payload("screenlogic/availability"); got != "offline" {
+		t.Errorf("availability is %q after a failed refresh, want %q", got, "offline")
+	}
+}
+
+func TestMQTTDiscoveryFollowsConfig(t *testing.T) {
+	fg := newFakePool(t)
+
+	// A pool only system: no spa circuit, even though the status still reports a spa.
+	config := fakePoolConfig()
+	config.ControllerType = protocol.ControllerTypeEasyTouch
+	config.Circuits = config.Circuits[1:]
+	fg.setConfig(config)
+
+	_, fm := newTestBridge(t, fg, ClientOptions{})
+
+	if payload, _ := fm.payload("homeassistant/climate/001122334455/pool/config"); payload == "" {
+		t.Error("no climate discovery for the pool")
+	}
+
+	if payload, ok := fm.payload("homeassistant/climate/001122334455/spa/config"); !ok || payload != "" {
+		t.Errorf("spa discovery wasn't removed, it's %q", payload)
+	}
+}
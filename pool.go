@@ -1,22 +1,29 @@
 package main
 
 import (
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
 	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
 )
 
 type PoolAccessory struct {
 	*accessory.Accessory
 
-	heater *WaterHeaterService
+	heater       *WaterHeaterService
+	statusActive *characteristic.StatusActive
+	setPoint     *setPointWriter
 
 	client *Client
 }
 
-func NewPoolAccessory(client *Client) *PoolAccessory {
+func NewPoolAccessory(client *Client, name string, manufacturer string, setPointDelay time.Duration) (*PoolAccessory, error) {
 	info := accessory.Info{
-		Name: "Pool",
+		Name: name,
 		// Model: "",
-		Manufacturer: "PentAir",
+		Manufacturer: manufacturer,
 		// SerialNumber: "",
 		// FirmwareRevision: "",
 	}
@@ -30,17 +37,31 @@ func NewPoolAccessory(client *Client) *PoolAccessory {
 	pool.heater = NewWaterHeaterService()
 	pool.AddService(pool.heater.Service)
 
-	pool.heater.displayUnits.OnValueRemoteGet(pool.client.GetTemperatureDisplayUnits)
+	addStatusFault(pool.heater.Service, client)
+
+	// The controller holds equipment off for a while after some changes, like switching valves
+	// between the pool and spa. Show that as the heater not being active yet.
+	pool.statusActive = characteristic.NewStatusActive()
+	pool.statusActive.OnValueRemoteGet(remoteGetBool(name, pool.statusActive.Bool, client.GetPoolStatusActive))
+	pool.heater.AddCharacteristic(pool.statusActive.Characteristic)
 
-	controllerConfig, err := client.getControllerConfig()
+	pool.heater.displayUnits.OnValueRemoteGet(remoteGetInt(name, pool.heater.displayUnits.Int, client.GetTemperatureDisplayUnits))
+
+	allowedMin, allowedMax, err := client.getSetPointRange(screenlogic.BodyOfWaterPool)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
-	allowedPoolRange := controllerConfig.AllowedPoolSetPointRange
+	min, err := client.convertTempToHomeKit(allowedMin)
+	if err != nil {
+		return nil, err
+	}
+
+	max, err := client.convertTempToHomeKit(allowedMax)
+	if err != nil {
+		return nil, err
+	}
 
-	min := client.convertTempToHomeKit(uint32(allowedPoolRange.Min))
-	max := client.convertTempToHomeKit(uint32(allowedPoolRange.Max))
 	step := float64(1.0)
 
 	// The current value must not be less than the minimum we configure, otherwise HomeKit will refuse
@@ -49,18 +70,36 @@ func NewPoolAccessory(client *Client) *PoolAccessory {
 	pool.heater.heatingThresholdTemperature.SetMinValue(min)
 	pool.heater.heatingThresholdTemperature.SetMaxValue(max)
 	pool.heater.heatingThresholdTemperature.SetStepValue(step)
-	pool.heater.heatingThresholdTemperature.OnValueRemoteGet(pool.client.GetPoolHeatingThresholdTemp)
+	pool.heater.heatingThresholdTemperature.OnValueRemoteGet(remoteGetFloat(name, pool.heater.heatingThresholdTemperature.Float, client.GetPoolHeatingThresholdTemp))
+
+	threshold := pool.heater.heatingThresholdTemperature
+
+	pool.setPoint = newSetPointWriter(name, setPointDelay, func(celsius float64) error {
+		return client.setHomeKitSetPoint(screenlogic.BodyOfWaterPool, celsius)
+	}, func() {
+		celsius, err := client.getHomeKitSetPoint(screenlogic.BodyOfWaterPool)
+		if err != nil {
+			log.Info.Printf("%s: unable to restore set point: %v\n", name, err)
+			return
+		}
 
-	pool.heater.HeaterCooler.Active.OnValueRemoteGet(pool.client.GetPoolHeaterActive)
+		threshold.SetValue(celsius)
+	})
+	pool.heater.heatingThresholdTemperature.OnValueRemoteUpdate(pool.setPoint.set)
 
-	pool.heater.HeaterCooler.CurrentHeaterCoolerState.OnValueRemoteGet(pool.client.GetPoolCurrentHeatingState)
+	pool.heater.HeaterCooler.Active.OnValueRemoteGet(remoteGetInt(name, pool.heater.HeaterCooler.Active.Int, client.GetPoolHeaterActive))
 
-	pool.heater.HeaterCooler.TargetHeaterCoolerState.OnValueRemoteGet(pool.client.GetPoolTargetHeatingState)
+	pool.heater.HeaterCooler.CurrentHeaterCoolerState.OnValueRemoteGet(remoteGetInt(name, pool.heater.HeaterCooler.CurrentHeaterCoolerState.Int, client.GetPoolCurrentHeatingState))
 
-	currentTemp := client.GetCurrentPoolTemp()
+	pool.heater.HeaterCooler.TargetHeaterCoolerState.OnValueRemoteGet(remoteGetInt(name, pool.heater.HeaterCooler.TargetHeaterCoolerState.Int, client.GetPoolTargetHeatingState))
+
+	currentTemp, err := client.GetCurrentPoolTemp()
+	if err != nil {
+		return nil, err
+	}
 
 	pool.heater.HeaterCooler.CurrentTemperature.SetValue(currentTemp)
-	pool.heater.HeaterCooler.CurrentTemperature.OnValueRemoteGet(pool.client.GetCurrentPoolTemp)
+	pool.heater.HeaterCooler.CurrentTemperature.OnValueRemoteGet(remoteGetFloat(name, pool.heater.HeaterCooler.CurrentTemperature.Float, client.GetCurrentPoolTemp))
 
-	return pool
+	return pool, nil
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+)
+
+// HomeKit has no way to be told a read failed. When the gateway can't be reached these log the error
+// and answer with the last value the characteristic had, and the accessory's StatusFault flags it as
+// not to be trusted. The last value is read from the characteristic directly, since its GetValue()
+// would call back into the same getter.
+
+// remoteGetFloat - adapts get for c.OnValueRemoteGet.
+func remoteGetFloat(name string, c *characteristic.Float, get func() (float64, error)) func() float64 {
+	return func() float64 {
+		value, err := get()
+		if err != nil {
+			log.Info.Printf("%s: %v\n", name, err)
+
+			value, _ = c.Characteristic.Value.(float64)
+		}
+
+		return value
+	}
+}
+
+// remoteGetInt - adapts get for c.OnValueRemoteGet.
+func remoteGetInt(name string, c *characteristic.Int, get func() (int, error)) func() int {
+	return func() int {
+		value, err := get()
+		if err != nil {
+			log.Info.Printf("%s: %v\n", name, err)
+
+			value, _ = c.Characteristic.Value.(int)
+		}
+
+		return value
+	}
+}
+
+// remoteGetBool - adapts get for c.OnValueRemoteGet.
+func remoteGetBool(name string, c *characteristic.Bool, get func() (bool, error)) func() bool {
+	return func() bool {
+		value, err := get()
+		if err != nil {
+			log.Info.Printf("%s: %v\n", name, err)
+
+			value, _ = c.Characteristic.Value.(bool)
+		}
+
+		return value
+	}
+}
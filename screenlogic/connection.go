@@ -0,0 +1,126 @@
+package screenlogic
+
+import (
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+	"github.com/brutella/hc/log"
+)
+
+// ConnectionHealth - how the connection to the gateway has been doing.
+type ConnectionHealth struct {
+	// LastRoundTrip is when we last got a reply to anything, including pings.
+	LastRoundTrip time.Time
+
+	// RTT is how long that reply took.
+	RTT time.Duration
+}
+
+// roundTrip - sends req and reads the reply into resp.
+func (g *Gateway) roundTrip(req protocol.WriteablePacket, resp protocol.ReadablePacket) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.roundTripLocked(req, resp)
+}
+
+// roundTripLocked - same as roundTrip, for when g.mutex is already held.
+func (g *Gateway) roundTripLocked(req protocol.WriteablePacket, resp protocol.ReadablePacket) error {
+	err := g.checkWritable(req)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	err = g.packetWriter.WritePacket(req)
+	if err != nil {
+		return err
+	}
+
+	err = g.packetReader.ReadPacket(resp)
+	if err != nil {
+		return err
+	}
+
+	g.health.LastRoundTrip = time.Now()
+	g.health.RTT = g.health.LastRoundTrip.Sub(start)
+
+	return nil
+}
+
+// Health - a snapshot of how the connection has been doing.
+func (g *Gateway) Health() ConnectionHealth {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.health
+}
+
+// Ping - asks the gateway for a reply and nothing else. Returns how long the reply took.
+func (g *Gateway) Ping() (time.Duration, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var req protocol.PingPacket
+
+	var resp protocol.PingResponsePacket
+
+	err := g.roundTripLocked(&req, &resp)
+	if err != nil {
+		return 0, err
+	}
+
+	return g.health.RTT, nil
+}
+
+// StartKeepalive - the gateway drops connections that have been idle for a while. This pings it
+// whenever nothing else has been sent for idle, until StopKeepalive or Close is called.
+//
+// Ping failures are only logged, the next real request will see the same error and can reconnect.
+func (g *Gateway) StartKeepalive(idle time.Duration) {
+	g.StopKeepalive()
+
+	done := make(chan struct{})
+
+	g.mutex.Lock()
+	g.keepaliveDone = done
+	g.mutex.Unlock()
+
+	interval := idle / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if time.Since(g.Health().LastRoundTrip) < idle {
+					continue
+				}
+
+				_, err := g.Ping()
+				if err != nil {
+					log.Info.Printf("keepalive: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// StopKeepalive - stops pinging the gateway. Calling this when the keepalive isn't running is fine.
+func (g *Gateway) StopKeepalive() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.keepaliveDone != nil {
+		close(g.keepaliveDone)
+		g.keepaliveDone = nil
+	}
+}
@@ -7,41 +7,71 @@ type ControllerConfiguration struct {
 }
 
 func (cc *ControllerConfiguration) HasSolar() bool {
-	return (cc.EquipmentFlags & 0x1) != 0
+	return cc.EquipmentFlags.Has(protocol.EquipmentFlagSolar)
 }
 
 func (cc *ControllerConfiguration) HasSolarAsHeatPump() bool {
-	return (cc.EquipmentFlags & 0x2) != 0
+	return cc.EquipmentFlags.Has(protocol.EquipmentFlagSolarAsHeatPump)
 }
 
 func (cc *ControllerConfiguration) HasChlorinator() bool {
-	return (cc.EquipmentFlags & 0x4) != 0
+	return cc.EquipmentFlags.Has(protocol.EquipmentFlagChlorinator)
 }
 
 func (cc *ControllerConfiguration) HasCooling() bool {
-	return (cc.EquipmentFlags & 0x800) != 0
+	return cc.EquipmentFlags.Has(protocol.EquipmentFlagCooling)
 }
 
 func (cc *ControllerConfiguration) HasIntellichem() bool {
-	return (cc.EquipmentFlags & 0x8000) != 0
+	return cc.EquipmentFlags.Has(protocol.EquipmentFlagIntelliChem)
 }
 
 func (cc *ControllerConfiguration) IsEasyTouch() bool {
-	return cc.ControllerType == 14 || cc.ControllerType == 13
+	return cc.ControllerType == protocol.ControllerTypeEasyTouch || cc.ControllerType == protocol.ControllerTypeEasyTouch2
 }
 
 func (cc *ControllerConfiguration) IsIntelliTouch() bool {
-	return cc.ControllerType != 14 && cc.ControllerType != 13 && cc.ControllerType != 10
+	return !cc.IsEasyTouch() && cc.ControllerType != protocol.ControllerTypeIntelliCom
 }
 
 func (cc *ControllerConfiguration) IsEasyTouchLite() bool {
-	return cc.ControllerType == 13 && (cc.HardwareType&0x4) != 0
+	return cc.ControllerType == protocol.ControllerTypeEasyTouch2 && (cc.HardwareType&0x4) != 0
 }
 
 func (cc *ControllerConfiguration) IsDualBody() bool {
-	return cc.ControllerType == 5
+	return cc.ControllerType == protocol.ControllerTypeIntelliTouchDualBody
+}
+
+// HasBody - whether the controller has body at all. Dual body controllers have both. Otherwise, each body
+// has a circuit of its own that switches the valves over to it, so a pool only system has no spa circuit.
+func (cc *ControllerConfiguration) HasBody(body BodyOfWater) bool {
+	if cc.IsDualBody() {
+		return true
+	}
+
+	function := protocol.CircuitFunctionPool
+	if body == BodyOfWaterSpa {
+		function = protocol.CircuitFunctionSpa
+	}
+
+	for _, circuit := range cc.Circuits {
+		if circuit.Function == function {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (cc *ControllerConfiguration) IsChem2() bool {
-	return cc.ControllerType == 252 && cc.HardwareType == 2
+	return cc.ControllerType == protocol.ControllerTypeChem2 && cc.HardwareType == 2
+}
+
+// Model - the controller's model name, taking the hardware type into account where it matters.
+func (cc *ControllerConfiguration) Model() string {
+	if cc.IsEasyTouchLite() {
+		return "EasyTouch Lite"
+	}
+
+	return cc.ControllerType.String()
 }
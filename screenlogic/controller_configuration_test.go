@@ -0,0 +1,47 @@
+package screenlogic
+
+import (
+	"testing"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+func TestHasBody(t *testing.T) {
+	circuits := func(functions ...protocol.CircuitFunction) []protocol.ControllerCircuit {
+		var cs []protocol.ControllerCircuit
+
+		for i, function := range functions {
+			cs = append(cs, protocol.ControllerCircuit{ID: uint32(500 + i), Function: function})
+		}
+
+		return cs
+	}
+
+	tests := []struct {
+		name           string
+		controllerType protocol.ControllerType
+		circuits       []protocol.ControllerCircuit
+		pool, spa      bool
+	}{
+		{"dual body", protocol.ControllerTypeIntelliTouchDualBody, nil, true, true},
+		{"shared pool and spa", protocol.ControllerTypeEasyTouch, circuits(protocol.CircuitFunctionSpa, protocol.CircuitFunctionPool, protocol.CircuitFunctionLight), true, true},
+		{"pool only", protocol.ControllerTypeEasyTouch, circuits(protocol.CircuitFunctionPool, protocol.CircuitFunctionCleaner), true, false},
+		{"spa only", protocol.ControllerTypeEasyTouch2, circuits(protocol.CircuitFunctionSpa), false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := &ControllerConfiguration{}
+			cc.ControllerType = tt.controllerType
+			cc.Circuits = tt.circuits
+
+			if got := cc.HasBody(BodyOfWaterPool); got != tt.pool {
+				t.Errorf("HasBody(pool) = %v, want %v", got, tt.pool)
+			}
+
+			if got := cc.HasBody(BodyOfWaterSpa); got != tt.spa {
+				t.Errorf("HasBody(spa) = %v, want %v", got, tt.spa)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package screenlogic
+
+import "github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+
+// Circuits with a NameIndex of at least this refer to an entry in the custom name table, rather than
+// one of the controller's built-in names.
+const customNameIndexOffset = 101
+
+// CustomNames - the names the installer has typed in for circuits that don't fit any of the built-in ones.
+type CustomNames []string
+
+// CircuitName - the name circuit should be shown with, looked up in the custom name table if it has one.
+func (cn CustomNames) CircuitName(circuit protocol.ControllerCircuit) string {
+	if circuit.NameIndex < customNameIndexOffset {
+		return circuit.Name
+	}
+
+	idx := int(circuit.NameIndex) - customNameIndexOffset
+
+	if idx >= len(cn) || cn[idx] == "" {
+		return circuit.Name
+	}
+
+	return cn[idx]
+}
+
+// ResolveNames - renames every circuit in cc that uses a custom name to what the table says.
+func (cc *ControllerConfiguration) ResolveNames(names CustomNames) {
+	for i := range cc.Circuits {
+		cc.Circuits[i].Name = names.CircuitName(cc.Circuits[i])
+	}
+}
+
+func (g *Gateway) CustomNames() (CustomNames, error) {
+	var req protocol.GetCustomNamesPacket
+	var resp protocol.GetCustomNamesResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return CustomNames(resp.Names), nil
+}
+
+// SetCustomName - changes entry idx (counting from 0) of the custom name table. Every circuit using
+// that entry is renamed along with it.
+func (g *Gateway) SetCustomName(idx uint32, name string) error {
+	var req protocol.SetCustomNamePacket
+
+	req.Index = idx
+	req.Name = name
+
+	var resp protocol.SetCustomNameResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package screenlogic
+
+import (
+	"fmt"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+// EquipmentConfiguration - the controller's detailed equipment setup. Only the version, expansion load
+// centers, high speed circuits, valve assignments, delay options and two of the misc options are decoded.
+//
+// Heater setup, pump circuit assignments, light groups and remotes aren't. Their layout changes with the
+// controller firmware, and there's no captured reply from a real controller to check a decoding against,
+// so guessing at one could only report equipment wrong. They're left as the raw arrays (RemoteData,
+// SensorData, MacroData, LightData, FlowData, SGData and SpaFlowData) on the embedded packet.
+type EquipmentConfiguration struct {
+	protocol.EquipmentConfigurationResponsePacket
+}
+
+// Valve - a valve actuator on one of the controller's load centers, and the circuit that drives it.
+type Valve struct {
+	LoadCenter int
+	Index      int
+	Name       string
+	DeviceID   uint8
+}
+
+// Delays - which equipment the controller keeps running (or stops) while it waits out a delay.
+type Delays struct {
+	PoolPumpOnDuringHeaterCooldown bool
+	SpaPumpOnDuringHeaterCooldown  bool
+	PumpOffDuringValveAction       bool
+}
+
+const valvesPerLoadCenter = 5
+
+// Version - the equipment configuration version, like "1.2".
+func (ec *EquipmentConfiguration) Version() string {
+	if len(ec.VersionData) < 2 {
+		return "unknown"
+	}
+
+	return fmt.Sprintf("%d.%d", ec.VersionData[0], ec.VersionData[1])
+}
+
+// ExpansionCount - how many expansion load centers are installed alongside the main one.
+func (ec *EquipmentConfiguration) ExpansionCount() int {
+	return int((ec.ControllerData & 0xc0) >> 6)
+}
+
+// HighSpeedCircuits - the device IDs of the circuits that run the pump at high speed.
+func (ec *EquipmentConfiguration) HighSpeedCircuits() []uint8 {
+	var circuits []uint8
+
+	for _, deviceID := range ec.SpeedData {
+		if deviceID != 0 {
+			circuits = append(circuits, deviceID)
+		}
+	}
+
+	return circuits
+}
+
+// IsHighSpeedCircuit - whether the circuit with deviceID runs the pump at high speed.
+func (ec *EquipmentConfiguration) IsHighSpeedCircuit(deviceID uint8) bool {
+	for _, id := range ec.HighSpeedCircuits() {
+		if id == deviceID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Valves - the valves that have a circuit assigned, across the main and any expansion load centers.
+// Valves are named by letter in the order the controller numbers them, starting at A.
+func (ec *EquipmentConfiguration) Valves() []Valve {
+	var valves []Valve
+
+	for loadCenter := 0; loadCenter <= ec.ExpansionCount(); loadCenter++ {
+		for index := 0; index < valvesPerLoadCenter; index++ {
+			// The first 4 bytes are flags, the circuit assignments follow in load center order.
+			offset := 4 + loadCenter*valvesPerLoadCenter + index
+
+			if offset >= len(ec.ValveData) {
+				return valves
+			}
+
+			deviceID := ec.ValveData[offset]
+			if deviceID == 0 {
+				continue
+			}
+
+			valves = append(valves, Valve{
+				LoadCenter: loadCenter,
+				Index:      index,
+				Name:       string(rune('A' + loadCenter*valvesPerLoadCenter + index)),
+				DeviceID:   deviceID,
+			})
+		}
+	}
+
+	return valves
+}
+
+func (ec *EquipmentConfiguration) Delays() Delays {
+	var flags uint8
+
+	if len(ec.DelayData) > 0 {
+		flags = ec.DelayData[0]
+	}
+
+	return Delays{
+		PoolPumpOnDuringHeaterCooldown: (flags & 0x1) != 0,
+		SpaPumpOnDuringHeaterCooldown:  (flags & 0x2) != 0,
+		PumpOffDuringValveAction:       (flags & 0x80) != 0,
+	}
+}
+
+func (ec *EquipmentConfiguration) HasIntelliChem() bool {
+	return len(ec.MiscData) > 3 && (ec.MiscData[3]&0x1) != 0
+}
+
+// HasManualHeat - whether the heater is only turned on by hand, rather than whenever its body is running.
+func (ec *EquipmentConfiguration) HasManualHeat() bool {
+	return len(ec.MiscData) > 4 && ec.MiscData[4] != 0
+}
+
+// EquipmentConfig - the controller's detailed equipment setup.
+func (g *Gateway) EquipmentConfig() (*EquipmentConfiguration, error) {
+	var req protocol.EquipmentConfigurationPacket
+
+	resp := &EquipmentConfiguration{}
+
+	err := g.roundTrip(&req, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
@@ -2,8 +2,12 @@ package screenlogic
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
@@ -11,22 +15,71 @@ import (
 )
 
 type Gateway struct {
+	// mutex is held for each request and its reply, so the keepalive can ping from another goroutine.
+	// It also guards the connection itself, and health.
+	mutex sync.Mutex
+
 	client         net.Conn
 	packetSequence uint16
 	clientName     string
 	packetReader   *protocol.PacketReader
 	packetWriter   *protocol.PacketWriter
 
+	subscriptions subscriptions
+
+	health        ConnectionHealth
+	keepaliveDone chan struct{}
+
+	// setPointRanges - the set points allowed for each body of water, as of the last ControllerConfig().
+	// nil until then.
+	setPointRanges map[BodyOfWater]protocol.SetPoint
+
 	IP      net.IP
 	Port    uint16
 	Type    uint8
 	Subnet  uint8
 	Name    string
 	MacAddr string
+
+	// Password is only required for remote connections. Local connections can leave this empty.
+	Password string
+
+	// ReadOnly refuses every request that would change anything on the controller, with ReadOnlyErr.
+	ReadOnly bool
 }
 
 const DiscoveryPort = 1444
 
+// DefaultPort - the port the gateway listens on for direct connections.
+const DefaultPort = 80
+
+// NewGateway - for when the gateway's address is already known, and discovery isn't needed (or
+// isn't possible, like when broadcasts don't make it across subnets). addr is host[:port], the port
+// defaults to DefaultPort.
+func NewGateway(addr string) (*Gateway, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		// No port was given
+		host = addr
+		portStr = strconv.Itoa(DefaultPort)
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway port %q", portStr)
+	}
+
+	ipAddr, err := net.ResolveIPAddr("ip4", host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Gateway{
+		IP:   ipAddr.IP,
+		Port: uint16(port),
+	}, nil
+}
+
 func DiscoverGateway() (*Gateway, error) {
 	addr := fmt.Sprintf("255.255.255.255:%d", DiscoveryPort)
 
@@ -74,20 +127,39 @@ func DiscoverGateway() (*Gateway, error) {
 }
 
 func (g *Gateway) handleOOBPacket(header *protocol.PacketHeader, data *bytes.Buffer) error {
-	// For now let's just log the type we saw and let the reader continue.
-	log.Info.Printf("OOB packet with type code %v - ignoring\n", header.TypeID)
+	if !g.dispatchOOBPacket(header, data) {
+		// Nobody wanted it, just log the type we saw and let the reader continue.
+		log.Info.Printf("OOB packet with type code %v - ignoring\n", header.TypeID)
+	}
 
 	return nil
 }
 
 func (g *Gateway) Connect() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.connectLocked()
+}
+
+// connectLocked - same as Connect, for when g.mutex is already held.
+func (g *Gateway) connectLocked() error {
 	var err error
 
-	g.client, err = net.Dial("tcp4", fmt.Sprintf("%s:%d", g.IP, g.Port))
+	// When reconnecting, don't leave the old connection open.
+	if g.client != nil {
+		g.client.Close()
+	}
+
+	// If this fails, g.client is left as the closed connection, so requests keep failing with a network
+	// error, and trying again, until it can be reached.
+	client, err := net.Dial("tcp4", net.JoinHostPort(g.IP.String(), strconv.Itoa(int(g.Port))))
 	if err != nil {
 		return err
 	}
 
+	g.client = client
+
 	// Setup packet processing
 	g.packetReader = protocol.NewPacketReader(g.client, g.handleOOBPacket)
 	g.packetWriter = protocol.NewPacketWriter(g.client, 2)
@@ -101,40 +173,50 @@ func (g *Gateway) Connect() error {
 
 	var challenge protocol.ChallengePacket
 
-	err = g.packetWriter.WritePacket(&challenge)
-	if err != nil {
-		return err
-	}
-
 	var resp protocol.ChallengePacketResponse
 
-	err = g.packetReader.ReadPacket(&resp)
+	err = g.roundTripLocked(&challenge, &resp)
 	if err != nil {
 		return err
 	}
 
 	g.MacAddr = resp.MacAddr
 
+	if g.Name == "" {
+		// Gateways we didn't discover don't know their name yet. Discovery names them after the
+		// last 3 octets of their mac address, so do the same here.
+		octets := strings.Split(g.MacAddr, "-")
+		if len(octets) > 3 {
+			octets = octets[len(octets)-3:]
+		}
+
+		g.Name = "Pentair: " + strings.ToUpper(strings.Join(octets, "-"))
+	}
+
 	return nil
 }
 
 func (g *Gateway) Login(clientName string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.loginLocked(clientName)
+}
+
+// loginLocked - same as Login, for when g.mutex is already held.
+func (g *Gateway) loginLocked(clientName string) error {
 	var req protocol.LoginPacket
 
 	req.Schema = 348       // this was picked up from another OSS client
 	req.ConnectionType = 0 // so was this
 	req.ClientName = clientName
-	req.Password = "" // TODO: allow passing password
-	req.PID = 2       // TODO: use our actual PID?
-
-	err := g.packetWriter.WritePacket(&req)
-	if err != nil {
-		return err
-	}
+	req.Password = g.Password
+	req.Challenge = g.MacAddr
+	req.PID = 2 // TODO: use our actual PID?
 
 	var resp protocol.LoginResponsePacket
 
-	err = g.packetReader.ReadPacket(&resp)
+	err := g.roundTripLocked(&req, &resp)
 	if err != nil {
 		return err
 	}
@@ -147,14 +229,9 @@ func (g *Gateway) Login(clientName string) error {
 func (g *Gateway) Version() (string, error) {
 	var req protocol.VersionPacket
 
-	err := g.packetWriter.WritePacket(&req)
-	if err != nil {
-		return "", err
-	}
-
 	var resp protocol.VersionResponsePacket
 
-	err = g.packetReader.ReadPacket(&resp)
+	err := g.roundTrip(&req, &resp)
 	if err != nil {
 		return "", err
 	}
@@ -165,32 +242,29 @@ func (g *Gateway) Version() (string, error) {
 func (g *Gateway) ControllerConfig() (*ControllerConfiguration, error) {
 	var req protocol.ControllerConfigurationPacket
 
-	err := g.packetWriter.WritePacket(&req)
-	if err != nil {
-		return nil, err
-	}
-
 	resp := &ControllerConfiguration{}
 
-	err = g.packetReader.ReadPacket(resp)
+	err := g.roundTrip(&req, resp)
 	if err != nil {
 		return nil, err
 	}
 
+	g.mutex.Lock()
+	g.setPointRanges = map[BodyOfWater]protocol.SetPoint{
+		BodyOfWaterPool: resp.AllowedPoolSetPointRange,
+		BodyOfWaterSpa:  resp.AllowedSpaSetPointRange,
+	}
+	g.mutex.Unlock()
+
 	return resp, nil
 }
 
 func (g *Gateway) PoolStatus() (*PoolStatus, error) {
 	var req protocol.PoolStatusPacket
 
-	err := g.packetWriter.WritePacket(&req)
-	if err != nil {
-		return nil, err
-	}
-
 	resp := &PoolStatus{}
 
-	err = g.packetReader.ReadPacket(resp)
+	err := g.roundTrip(&req, resp)
 	if err != nil {
 		return nil, err
 	}
@@ -205,21 +279,29 @@ const (
 	BodyOfWaterSpa
 )
 
+var SetPointOutOfRangeErr = errors.New("set point out of range")
+
+// SetTemperature - sets the heat set point for bodyType, which must be within the range the controller allows
+// for it. temperature is in the controller's units.
 func (g *Gateway) SetTemperature(controllerIdx uint32, bodyType BodyOfWater, temperature uint32) error {
+	allowed, err := g.setPointRange(bodyType)
+	if err != nil {
+		return err
+	}
+
+	if temperature < uint32(allowed.Min) || temperature > uint32(allowed.Max) {
+		return fmt.Errorf("%w: %d is outside of %d-%d", SetPointOutOfRangeErr, temperature, allowed.Min, allowed.Max)
+	}
+
 	var req protocol.SetHeatPointPacket
 
 	req.ControllerIdx = controllerIdx
 	req.BodyType = uint32(bodyType)
 	req.Temperature = temperature
 
-	err := g.packetWriter.WritePacket(&req)
-	if err != nil {
-		return err
-	}
-
 	var resp protocol.SetHeatPointResponsePacket
 
-	err = g.packetReader.ReadPacket(&resp)
+	err = g.roundTrip(&req, &resp)
 	if err != nil {
 		return err
 	}
@@ -227,6 +309,31 @@ func (g *Gateway) SetTemperature(controllerIdx uint32, bodyType BodyOfWater, tem
 	return nil
 }
 
+// setPointRange - the set points the controller allows for bodyType, from the last ControllerConfig(). The
+// range only changes when the controller is reconfigured, so the configuration is only asked for if it
+// never has been.
+func (g *Gateway) setPointRange(bodyType BodyOfWater) (protocol.SetPoint, error) {
+	g.mutex.Lock()
+	ranges := g.setPointRanges
+	g.mutex.Unlock()
+
+	if ranges == nil {
+		_, err := g.ControllerConfig()
+		if err != nil {
+			return protocol.SetPoint{}, err
+		}
+
+		g.mutex.Lock()
+		ranges = g.setPointRanges
+		g.mutex.Unlock()
+	}
+
+	return ranges[bodyType], nil
+}
+
+// NoSolarErr - returned for solar heat modes on controllers without solar heating.
+var NoSolarErr = errors.New("controller has no solar heating")
+
 type HeatMode uint32
 
 const (
@@ -237,6 +344,34 @@ const (
 	HeatModeUnchanged
 )
 
+func (hm HeatMode) String() string {
+	switch hm {
+	case HeatModeOff:
+		return "off"
+	case HeatModeSolarOnly:
+		return "solar"
+	case HeatModeSolarPreferred:
+		return "solar-preferred"
+	case HeatModeOn:
+		return "on"
+	case HeatModeUnchanged:
+		return "unchanged"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(hm))
+	}
+}
+
+// ParseHeatMode - the inverse of HeatMode.String(), for the modes that can actually be set.
+func ParseHeatMode(name string) (HeatMode, error) {
+	for _, mode := range []HeatMode{HeatModeOff, HeatModeSolarOnly, HeatModeSolarPreferred, HeatModeOn} {
+		if strings.EqualFold(mode.String(), name) {
+			return mode, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid heat mode %q", name)
+}
+
 func (g *Gateway) SetHeatMode(controllerIdx uint32, bodyType BodyOfWater, mode HeatMode) error {
 	var req protocol.SetHeatModePacket
 
@@ -244,14 +379,101 @@ func (g *Gateway) SetHeatMode(controllerIdx uint32, bodyType BodyOfWater, mode H
 	req.BodyType = uint32(bodyType)
 	req.Mode = uint32(mode)
 
-	err := g.packetWriter.WritePacket(&req)
+	var resp protocol.SetHeatModeResponsePacket
+
+	err := g.roundTrip(&req, &resp)
 	if err != nil {
 		return err
 	}
 
-	var resp protocol.SetHeatModeResponsePacket
+	return nil
+}
+
+func (g *Gateway) SetCircuitState(controllerIdx uint32, circuitID uint32, on bool) error {
+	var req protocol.SetCircuitStatePacket
+
+	req.ControllerIdx = controllerIdx
+	req.CircuitID = circuitID
+	req.State = 0
+
+	if on {
+		req.State = 1
+	}
+
+	var resp protocol.SetCircuitStateResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MaxCircuitRuntime - the longest egg timer the controller accepts.
+const MaxCircuitRuntime = 12 * time.Hour
+
+// SetCircuitRuntime - sets the circuit's egg timer: how long it stays on for after it's turned on,
+// before the controller turns it back off. The controller works in whole minutes.
+func (g *Gateway) SetCircuitRuntime(circuitID uint32, runtime time.Duration) error {
+	if runtime < time.Minute || runtime > MaxCircuitRuntime {
+		return fmt.Errorf("circuit runtime must be between %v and %v, got %v", time.Minute, MaxCircuitRuntime, runtime)
+	}
+
+	var req protocol.SetCircuitRuntimePacket
+
+	req.CircuitID = circuitID
+	req.Runtime = uint32(runtime.Round(time.Minute) / time.Minute)
+
+	var resp protocol.SetCircuitRuntimeResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
 
-	err = g.packetReader.ReadPacket(&resp)
+// SystemTime - the controller's clock, and whether it adjusts itself for daylight saving time.
+func (g *Gateway) SystemTime() (time.Time, bool, error) {
+	var req protocol.GetSystemTimePacket
+
+	var resp protocol.GetSystemTimeResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return resp.Time, resp.AdjustForDST, nil
+}
+
+// SetSystemTime - sets the controller's clock to t, converted to local time.
+func (g *Gateway) SetSystemTime(t time.Time, adjustForDST bool) error {
+	var req protocol.SetSystemTimePacket
+	req.Time = t
+	req.AdjustForDST = adjustForDST
+
+	var resp protocol.SetSystemTimeResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CancelDelay - cancels any pool, spa or cleaner delays that are holding equipment off, like the
+// valve delay after switching between pool and spa.
+func (g *Gateway) CancelDelay() error {
+	var req protocol.CancelDelayPacket
+	req.ControllerIndex = 0
+
+	var resp protocol.CancelDelayResponsePacket
+
+	err := g.roundTrip(&req, &resp)
 	if err != nil {
 		return err
 	}
@@ -266,14 +488,13 @@ func (g *Gateway) History(start, end time.Time) (*protocol.HistoryDataResponsePa
 	req.End = end
 	req.SenderID = 0
 
-	err := g.packetWriter.WritePacket(&req)
-	if err != nil {
-		return nil, err
-	}
+	// The data comes in a second packet after the reply, so hold the lock until we have both.
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 
 	var historyResp protocol.HistoryResponsePacket
 
-	err = g.packetReader.ReadPacket(&historyResp)
+	err := g.roundTripLocked(&req, &historyResp)
 	if err != nil {
 		return nil, err
 	}
@@ -288,15 +509,88 @@ func (g *Gateway) History(start, end time.Time) (*protocol.HistoryDataResponsePa
 	return dataResp, nil
 }
 
+func (g *Gateway) Schedules(scheduleType ScheduleType) ([]Schedule, error) {
+	var req protocol.GetScheduleDataPacket
+	req.ControllerIndex = 0
+	req.ScheduleType = uint32(scheduleType)
+
+	var resp protocol.GetScheduleDataResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]Schedule, len(resp.Events))
+
+	for i := range resp.Events {
+		schedules[i] = newScheduleFromEvent(&resp.Events[i])
+	}
+
+	return schedules, nil
+}
+
+// CreateSchedule - adds a new schedule to the controller, then fills it in with the
+// contents of schedule. On success, schedule.ID is set to the ID the controller assigned.
+func (g *Gateway) CreateSchedule(schedule *Schedule) error {
+	var req protocol.AddScheduleEventPacket
+	req.ControllerIndex = 0
+	req.ScheduleType = uint32(schedule.Type)
+
+	var resp protocol.AddScheduleEventResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return err
+	}
+
+	schedule.ID = resp.ScheduleID
+
+	return g.UpdateSchedule(schedule)
+}
+
+func (g *Gateway) UpdateSchedule(schedule *Schedule) error {
+	var req protocol.SetScheduleEventPacket
+	req.ControllerIndex = 0
+	req.ScheduleEvent = schedule.event()
+
+	var resp protocol.SetScheduleEventResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (g *Gateway) DeleteSchedule(scheduleID uint32) error {
+	var req protocol.DeleteScheduleEventPacket
+	req.ControllerIndex = 0
+	req.ScheduleID = scheduleID
+
+	var resp protocol.DeleteScheduleEventResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Reconnect - connects and logs in again with the same client name. g.mutex is held throughout, so
+// nothing else, like a keepalive ping, gets sent on the new connection before it's logged in.
 func (g *Gateway) Reconnect() error {
-	// OpError
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 
-	err := g.Connect()
+	err := g.connectLocked()
 	if err != nil {
 		return err
 	}
 
-	err = g.Login(g.clientName)
+	err = g.loginLocked(g.clientName)
 	if err != nil {
 		return err
 	}
@@ -305,5 +599,10 @@ func (g *Gateway) Reconnect() error {
 }
 
 func (g *Gateway) Close() {
+	g.StopKeepalive()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
 	g.client.Close()
 }
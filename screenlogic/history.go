@@ -0,0 +1,200 @@
+package screenlogic
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+// DefaultHistoryChunk - the largest range we'll ask the gateway for in a single history request.
+// Asking for much more than this at once tends to make the gateway take a very long time to respond.
+const DefaultHistoryChunk = 24 * time.Hour
+
+// HistoryRange - like History, but splits the range into requests of at most chunk in length
+// and merges the results together. Events that show up in more than one chunk (like a heater run
+// that spans a chunk boundary) are only included once.
+func (g *Gateway) HistoryRange(start, end time.Time, chunk time.Duration) (*protocol.HistoryDataResponsePacket, error) {
+	if chunk <= 0 {
+		chunk = DefaultHistoryChunk
+	}
+
+	merged := &protocol.HistoryDataResponsePacket{}
+
+	for chunkStart := start; chunkStart.Before(end); chunkStart = chunkStart.Add(chunk) {
+		chunkEnd := chunkStart.Add(chunk)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+
+		history, err := g.History(chunkStart, chunkEnd)
+		if err != nil {
+			return nil, err
+		}
+
+		merged.OutsideTemps = mergeHistoryEvents(merged.OutsideTemps, history.OutsideTemps)
+		merged.PoolWaterTemps = mergeHistoryEvents(merged.PoolWaterTemps, history.PoolWaterTemps)
+		merged.HotTubWaterTemps = mergeHistoryEvents(merged.HotTubWaterTemps, history.HotTubWaterTemps)
+		merged.PoolRuns = mergeStartStopEvents(merged.PoolRuns, history.PoolRuns)
+		merged.HotTubRuns = mergeStartStopEvents(merged.HotTubRuns, history.HotTubRuns)
+		merged.SolarRuns = mergeStartStopEvents(merged.SolarRuns, history.SolarRuns)
+		merged.HeaterRuns = mergeStartStopEvents(merged.HeaterRuns, history.HeaterRuns)
+		merged.LightRuns = mergeStartStopEvents(merged.LightRuns, history.LightRuns)
+	}
+
+	return merged, nil
+}
+
+func mergeHistoryEvents(existing, more []protocol.HistoryEvent) []protocol.HistoryEvent {
+	seen := make(map[time.Time]bool, len(existing))
+
+	for _, event := range existing {
+		seen[event.Timestamp] = true
+	}
+
+	for _, event := range more {
+		if !seen[event.Timestamp] {
+			seen[event.Timestamp] = true
+			existing = append(existing, event)
+		}
+	}
+
+	return existing
+}
+
+func mergeStartStopEvents(existing, more []protocol.StartStopEvent) []protocol.StartStopEvent {
+	seen := make(map[time.Time]bool, len(existing))
+
+	for _, event := range existing {
+		seen[event.Start] = true
+	}
+
+	for _, event := range more {
+		if !seen[event.Start] {
+			seen[event.Start] = true
+			existing = append(existing, event)
+		}
+	}
+
+	return existing
+}
+
+// HistoryRecord - a single row of exported history. Temperature series only set Temperature and Unit,
+// while run series (pool, spa, solar, heater, light) only set End and Duration.
+type HistoryRecord struct {
+	Series      string          `json:"series"`
+	Start       time.Time       `json:"start"`
+	End         *time.Time      `json:"end,omitempty"`
+	Duration    float64         `json:"duration_seconds,omitempty"`
+	Temperature *float64        `json:"temperature,omitempty"`
+	Unit        TemperatureUnit `json:"unit,omitempty"`
+}
+
+// HistoryRecords - flattens history into a list of records sorted by start time. Temperatures are
+// converted from the controller's units (from) to the requested units (to).
+func HistoryRecords(history *protocol.HistoryDataResponsePacket, from, to TemperatureUnit) []HistoryRecord {
+	var records []HistoryRecord
+
+	temps := []struct {
+		series string
+		events []protocol.HistoryEvent
+	}{
+		{"outside_temp", history.OutsideTemps},
+		{"pool_temp", history.PoolWaterTemps},
+		{"spa_temp", history.HotTubWaterTemps},
+	}
+
+	for _, t := range temps {
+		for _, event := range t.events {
+			temp := ConvertTemperature(float64(event.Temp), from, to)
+
+			records = append(records, HistoryRecord{
+				Series:      t.series,
+				Start:       event.Timestamp,
+				Temperature: &temp,
+				Unit:        to,
+			})
+		}
+	}
+
+	runs := []struct {
+		series string
+		events []protocol.StartStopEvent
+	}{
+		{"pool_run", history.PoolRuns},
+		{"spa_run", history.HotTubRuns},
+		{"solar_run", history.SolarRuns},
+		{"heater_run", history.HeaterRuns},
+		{"light_run", history.LightRuns},
+	}
+
+	for _, r := range runs {
+		for _, event := range r.events {
+			stop := event.Stop
+
+			records = append(records, HistoryRecord{
+				Series:   r.series,
+				Start:    event.Start,
+				End:      &stop,
+				Duration: event.Stop.Sub(event.Start).Seconds(),
+			})
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Start.Before(records[j].Start)
+	})
+
+	return records
+}
+
+// WriteHistoryCSV - writes records as CSV, with a header row. Fields that don't apply to a
+// record's series are left empty.
+func WriteHistoryCSV(w io.Writer, records []HistoryRecord) error {
+	writer := csv.NewWriter(w)
+
+	err := writer.Write([]string{"series", "start", "end", "duration_seconds", "temperature", "unit"})
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		row := []string{record.Series, record.Start.Format(time.RFC3339), "", "", "", string(record.Unit)}
+
+		if record.End != nil {
+			row[2] = record.End.Format(time.RFC3339)
+			row[3] = strconv.FormatFloat(record.Duration, 'f', 0, 64)
+		}
+
+		if record.Temperature != nil {
+			row[4] = strconv.FormatFloat(*record.Temperature, 'f', 1, 64)
+		}
+
+		err = writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// WriteHistoryJSONLines - writes records as JSON Lines, one JSON object per record.
+func WriteHistoryJSONLines(w io.Writer, records []HistoryRecord) error {
+	encoder := json.NewEncoder(w)
+
+	for i := range records {
+		err := encoder.Encode(&records[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
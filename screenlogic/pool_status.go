@@ -37,3 +37,8 @@ func (ps *PoolStatus) IsSync() bool {
 func (ps *PoolStatus) IsInServiceMode() bool {
 	return ps.OK == 3
 }
+
+// HasDelay - true if a pool, spa or cleaner delay is holding any equipment off.
+func (ps *PoolStatus) HasDelay() bool {
+	return ps.PoolDelay != 0 || ps.SpaDelay != 0 || ps.CleanerDelay != 0
+}
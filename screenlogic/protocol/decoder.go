@@ -93,6 +93,17 @@ func (d *Decoder) ReadString() (string, error) {
 	return string(buf[:len]), nil
 }
 
+// ReadArray - reads a length-prefixed byte array, which is padded to a multiple of 4 bytes
+// the same way strings are.
+func (d *Decoder) ReadArray() ([]byte, error) {
+	s, err := d.ReadString()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(s), nil
+}
+
 func (d *Decoder) ReadDateTime() (time.Time, error) {
 	year, err := d.ReadUint16()
 	if err != nil {
@@ -104,7 +115,7 @@ func (d *Decoder) ReadDateTime() (time.Time, error) {
 		return time.Time{}, err
 	}
 
-	// weekday, not used
+	// weekday, which time.Date works out for itself
 	_, err = d.ReadUint16()
 	if err != nil {
 		return time.Time{}, err
@@ -130,13 +141,14 @@ func (d *Decoder) ReadDateTime() (time.Time, error) {
 		return time.Time{}, err
 	}
 
-	// millisecond, but who cares
-	_, err = d.ReadUint16()
+	millisecond, err := d.ReadUint16()
 	if err != nil {
 		return time.Time{}, err
 	}
 
-	return time.Date(int(year), time.Month(month), int(day), int(hour), int(minute), int(second), 0, time.Local), nil
+	nsec := int(millisecond) * int(time.Millisecond)
+
+	return time.Date(int(year), time.Month(month), int(day), int(hour), int(minute), int(second), nsec, time.Local), nil
 }
 
 func (d *Decoder) CopyBytes(data []byte) error {
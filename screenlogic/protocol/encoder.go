@@ -50,6 +50,10 @@ func (e *Encoder) WriteString(val string) error {
 	// multiples of 4 bytes. Make sure we write the padded bytes as well.
 	pad := 4 - (len % 4)
 
+	if pad == 4 {
+		pad = 0
+	}
+
 	if pad > 0 {
 		padBytes := make([]byte, pad)
 
@@ -90,9 +94,8 @@ func (e *Encoder) WriteDateTime(t time.Time) error {
 		return err
 	}
 
-	// Day of the week, can be 0.
-	// Could use t.Weekday() if need-be.
-	err = e.WriteUint16(uint16(0))
+	// Day of the week, 0 is Sunday, same as time.Weekday.
+	err = e.WriteUint16(uint16(t.Weekday()))
 	if err != nil {
 		return err
 	}
@@ -118,5 +121,5 @@ func (e *Encoder) WriteDateTime(t time.Time) error {
 	}
 
 	// last field is millisecond
-	return e.WriteUint16(0)
+	return e.WriteUint16(uint16(t.Nanosecond() / int(time.Millisecond)))
 }
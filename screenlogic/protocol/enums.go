@@ -0,0 +1,228 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CircuitFunction - what a circuit controls, which decides how the controller treats it.
+type CircuitFunction uint8
+
+const (
+	CircuitFunctionGeneric CircuitFunction = iota
+	CircuitFunctionSpa
+	CircuitFunctionPool
+	CircuitFunctionSecondSpa
+	CircuitFunctionSecondPool
+	CircuitFunctionMasterCleaner
+	CircuitFunctionCleaner
+	CircuitFunctionLight
+	CircuitFunctionDimmer
+	CircuitFunctionSAmLight
+	CircuitFunctionSALLight
+	CircuitFunctionPhotonGen
+	CircuitFunctionColorWheel
+	CircuitFunctionValve
+	CircuitFunctionSpillway
+	CircuitFunctionFloorCleaner
+	CircuitFunctionIntelliBrite
+	CircuitFunctionMagicStream
+	CircuitFunctionDimmer25
+)
+
+var circuitFunctionNames = map[CircuitFunction]string{
+	CircuitFunctionGeneric:       "generic",
+	CircuitFunctionSpa:           "spa",
+	CircuitFunctionPool:          "pool",
+	CircuitFunctionSecondSpa:     "second spa",
+	CircuitFunctionSecondPool:    "second pool",
+	CircuitFunctionMasterCleaner: "master cleaner",
+	CircuitFunctionCleaner:       "cleaner",
+	CircuitFunctionLight:         "light",
+	CircuitFunctionDimmer:        "dimmer",
+	CircuitFunctionSAmLight:      "SAm light",
+	CircuitFunctionSALLight:      "SAL light",
+	CircuitFunctionPhotonGen:     "Photon Gen",
+	CircuitFunctionColorWheel:    "color wheel",
+	CircuitFunctionValve:         "valve",
+	CircuitFunctionSpillway:      "spillway",
+	CircuitFunctionFloorCleaner:  "floor cleaner",
+	CircuitFunctionIntelliBrite:  "IntelliBrite",
+	CircuitFunctionMagicStream:   "MagicStream",
+	CircuitFunctionDimmer25:      "dimmer 25",
+}
+
+func (cf CircuitFunction) String() string {
+	name, ok := circuitFunctionNames[cf]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", uint8(cf))
+	}
+
+	return name
+}
+
+// IsLight - whether the circuit drives a light of any kind.
+func (cf CircuitFunction) IsLight() bool {
+	switch cf {
+	case CircuitFunctionLight, CircuitFunctionDimmer, CircuitFunctionSAmLight, CircuitFunctionSALLight,
+		CircuitFunctionPhotonGen, CircuitFunctionColorWheel, CircuitFunctionIntelliBrite,
+		CircuitFunctionMagicStream, CircuitFunctionDimmer25:
+		return true
+	default:
+		return false
+	}
+}
+
+// CircuitInterface - which tab of the ScreenLogic app a circuit is shown on, if any.
+type CircuitInterface uint8
+
+const (
+	CircuitInterfacePool CircuitInterface = iota
+	CircuitInterfaceSpa
+	CircuitInterfaceFeatures
+	CircuitInterfaceSyncSwim
+	CircuitInterfaceLights
+	CircuitInterfaceHidden
+	CircuitInterfaceInvalid
+)
+
+var circuitInterfaceNames = map[CircuitInterface]string{
+	CircuitInterfacePool:     "pool",
+	CircuitInterfaceSpa:      "spa",
+	CircuitInterfaceFeatures: "features",
+	CircuitInterfaceSyncSwim: "sync swim",
+	CircuitInterfaceLights:   "lights",
+	CircuitInterfaceHidden:   "hidden",
+	CircuitInterfaceInvalid:  "invalid",
+}
+
+func (ci CircuitInterface) String() string {
+	name, ok := circuitInterfaceNames[ci]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", uint8(ci))
+	}
+
+	return name
+}
+
+// CircuitFlags - per-circuit options.
+type CircuitFlags uint8
+
+const (
+	CircuitFlagFreezeProtection CircuitFlags = 0x1
+)
+
+func (cf CircuitFlags) String() string {
+	if cf == 0 {
+		return "none"
+	}
+
+	var names []string
+
+	if cf&CircuitFlagFreezeProtection != 0 {
+		names = append(names, "freeze protection")
+	}
+
+	if unknown := cf &^ CircuitFlagFreezeProtection; unknown != 0 {
+		names = append(names, fmt.Sprintf("unknown(0x%x)", uint8(unknown)))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// EquipmentFlags - bitmask of the optional equipment installed on the controller.
+type EquipmentFlags uint32
+
+const (
+	EquipmentFlagSolar           EquipmentFlags = 0x1
+	EquipmentFlagSolarAsHeatPump EquipmentFlags = 0x2
+	EquipmentFlagChlorinator     EquipmentFlags = 0x4
+	EquipmentFlagIntelliBrite    EquipmentFlags = 0x8
+	EquipmentFlagIntelliFlo0     EquipmentFlags = 0x10
+	EquipmentFlagIntelliFlo1     EquipmentFlags = 0x20
+	EquipmentFlagIntelliFlo2     EquipmentFlags = 0x40
+	EquipmentFlagIntelliFlo3     EquipmentFlags = 0x80
+	EquipmentFlagCooling         EquipmentFlags = 0x800
+	EquipmentFlagNoSpecialLights EquipmentFlags = 0x1000
+	EquipmentFlagMagicStream     EquipmentFlags = 0x4000
+	EquipmentFlagIntelliChem     EquipmentFlags = 0x8000
+	EquipmentFlagHybridHeater    EquipmentFlags = 0x10000
+)
+
+var equipmentFlagNames = []struct {
+	flag EquipmentFlags
+	name string
+}{
+	{EquipmentFlagSolar, "solar"},
+	{EquipmentFlagSolarAsHeatPump, "solar as heat pump"},
+	{EquipmentFlagChlorinator, "chlorinator"},
+	{EquipmentFlagIntelliBrite, "IntelliBrite"},
+	{EquipmentFlagIntelliFlo0, "IntelliFlo 1"},
+	{EquipmentFlagIntelliFlo1, "IntelliFlo 2"},
+	{EquipmentFlagIntelliFlo2, "IntelliFlo 3"},
+	{EquipmentFlagIntelliFlo3, "IntelliFlo 4"},
+	{EquipmentFlagCooling, "cooling"},
+	{EquipmentFlagNoSpecialLights, "no special lights"},
+	{EquipmentFlagMagicStream, "MagicStream"},
+	{EquipmentFlagIntelliChem, "IntelliChem"},
+	{EquipmentFlagHybridHeater, "hybrid heater"},
+}
+
+func (ef EquipmentFlags) Has(flag EquipmentFlags) bool {
+	return (ef & flag) != 0
+}
+
+func (ef EquipmentFlags) String() string {
+	if ef == 0 {
+		return "none"
+	}
+
+	var names []string
+
+	unknown := ef
+
+	for _, f := range equipmentFlagNames {
+		if ef.Has(f.flag) {
+			names = append(names, f.name)
+			unknown &^= f.flag
+		}
+	}
+
+	if unknown != 0 {
+		names = append(names, fmt.Sprintf("unknown(0x%x)", uint32(unknown)))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// ControllerType - the model of controller the gateway is attached to.
+type ControllerType uint8
+
+const (
+	ControllerTypeIntelliTouchDualBody ControllerType = 5
+	ControllerTypeIntelliCom           ControllerType = 10
+	ControllerTypeEasyTouch2           ControllerType = 13
+	ControllerTypeEasyTouch            ControllerType = 14
+	ControllerTypeChem2                ControllerType = 252
+)
+
+func (ct ControllerType) String() string {
+	switch ct {
+	case ControllerTypeIntelliTouchDualBody:
+		return "IntelliTouch (dual body)"
+	case ControllerTypeIntelliCom:
+		return "IntelliCom"
+	case ControllerTypeEasyTouch2:
+		return "EasyTouch 2"
+	case ControllerTypeEasyTouch:
+		return "EasyTouch"
+	case ControllerTypeChem2:
+		return "IntelliChem"
+	default:
+		// Everything else is one of the IntelliTouch models.
+		return fmt.Sprintf("IntelliTouch(%d)", uint8(ct))
+	}
+}
+
+// HardwareType - the hardware revision of the controller, whose meaning depends on the ControllerType.
+type HardwareType uint8
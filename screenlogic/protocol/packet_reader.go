@@ -35,11 +35,13 @@ readAgain:
 		return err
 	}
 
-	// If this packet has a body, read it then pass it along for decoding.
+	// If this packet has a body, read it so it can be passed along for decoding.
+	var dataBuf *bytes.Buffer
+
 	if header.Len > 0 {
 		limitReader := io.LimitReader(pp.r, int64(header.Len))
 
-		dataBuf := new(bytes.Buffer)
+		dataBuf = new(bytes.Buffer)
 
 		n, err := dataBuf.ReadFrom(limitReader)
 		if err != nil {
@@ -49,29 +51,40 @@ readAgain:
 		if n != int64(header.Len) {
 			return TruncatedPacketError
 		}
+	}
 
-		expectedTypeCode := p.TypeCode()
-
-		if header.TypeID != expectedTypeCode {
-			// What I noticed is that there are some packets the gateway will send us even if
-			// we never asked for them. Out of order of the regular request/response cycle.
-			// One such packet is the WeatherForcastChanged packet.
-			// We'll hand these packets over to the caller so they can decide what to do with them.
-			// If this callback returns an error, we stop reading packets here and return that error.
-			if pp.callback != nil {
-				err = pp.callback(header, dataBuf)
-				if err != nil {
-					return err
-				}
+	// Error replies are still the answer to whatever we just asked for, rather than out of band packets,
+	// but there's nothing in them to decode.
+	switch header.TypeID {
+	case LoginFailedCode:
+		return LoginFailedErr
+	case BadParameterCode:
+		return BadParameterErr
+	}
+
+	expectedTypeCode := p.TypeCode()
+
+	if header.TypeID != expectedTypeCode {
+		// What I noticed is that there are some packets the gateway will send us even if
+		// we never asked for them. Out of order of the regular request/response cycle.
+		// One such packet is the WeatherForcastChanged packet, which has no body at all.
+		// We'll hand these packets over to the caller so they can decide what to do with them.
+		// If this callback returns an error, we stop reading packets here and return that error.
+		if pp.callback != nil {
+			if dataBuf == nil {
+				dataBuf = new(bytes.Buffer)
 			}
 
-			// As for the packet the caller was most likely expecting here, it's probably next in
-			// line off the socket buffer. So let's read that now, shall we?
-			goto readAgain
+			err = pp.callback(header, dataBuf)
+			if err != nil {
+				return err
+			}
 		}
 
-		return p.Decode(header, dataBuf)
+		// As for the packet the caller was most likely expecting here, it's probably next in
+		// line off the socket buffer. So let's read that now, shall we?
+		goto readAgain
 	}
 
-	return p.Decode(header, nil)
+	return p.Decode(header, dataBuf)
 }
@@ -3,7 +3,6 @@ package protocol
 import (
 	"bytes"
 	"errors"
-	"log"
 	"net"
 	"time"
 )
@@ -11,29 +10,69 @@ import (
 const (
 	LoginFailedCode                           uint16 = 13
 	ChallengePacketCode                              = 14
+	PingPacketCode                                   = 16
+	PingResponsePacketCode                           = PingPacketCode + 1
 	ChallengePacketResponseCode                      = ChallengePacketCode + 1
 	LoginPacketCode                                  = 27
 	LoginResponsePacketCode                          = LoginPacketCode + 1
 	BadParameterCode                                 = 31
+	GetSystemTimePacketCode                          = 8110
+	GetSystemTimeResponsePacketCode                  = GetSystemTimePacketCode + 1
+	SetSystemTimePacketCode                          = 8112
+	SetSystemTimeResponsePacketCode                  = SetSystemTimePacketCode + 1
 	VersionPacketCode                                = 8120
 	VersionResponsePacketCode                        = VersionPacketCode + 1
 	WeatherForcastChangedCode                        = 9806
+	WeatherForecastPacketCode                        = 9807
+	WeatherForecastResponsePacketCode                = WeatherForecastPacketCode + 1
+	StatusChangedPacketCode                          = 12500
 	HistoryDataResponsePacketCode                    = 12502
+	ColorUpdatePacketCode                            = 12504
+	ChemistryChangedPacketCode                       = 12505
+	AddClientPacketCode                              = 12522
+	AddClientResponsePacketCode                      = AddClientPacketCode + 1
+	RemoveClientPacketCode                           = 12524
+	RemoveClientResponsePacketCode                   = RemoveClientPacketCode + 1
 	ControllerConfigurationPacketCode                = 12532
 	ControllerConfigurationResponsePacketCode        = ControllerConfigurationPacketCode + 1
 	PoolStatusPacketCode                             = 12526
 	PoolStatusResponsePacketCode                     = PoolStatusPacketCode + 1
 	SetHeatPointPacketCode                           = 12528
 	SetHeatPointResponsePacketCode                   = SetHeatPointPacketCode + 1
+	SetCircuitStatePacketCode                        = 12530
+	SetCircuitStateResponsePacketCode                = SetCircuitStatePacketCode + 1
 	HistoryPacketCode                                = 12534
 	HistoryPacketResponseCode                        = HistoryPacketCode + 1
 	SetHeatModePacketCode                            = 12538
 	SetHeatModeResponsePacketCode                    = SetHeatModePacketCode + 1
+	GetScheduleDataPacketCode                        = 12542
+	GetScheduleDataResponsePacketCode                = GetScheduleDataPacketCode + 1
+	AddScheduleEventPacketCode                       = 12544
+	AddScheduleEventResponsePacketCode               = AddScheduleEventPacketCode + 1
+	DeleteScheduleEventPacketCode                    = 12546
+	DeleteScheduleEventResponsePacketCode            = DeleteScheduleEventPacketCode + 1
+	SetScheduleEventPacketCode                       = 12548
+	SetScheduleEventResponsePacketCode               = SetScheduleEventPacketCode + 1
+	SetCircuitRuntimePacketCode                      = 12550
+	SetCircuitRuntimeResponsePacketCode              = SetCircuitRuntimePacketCode + 1
+	GetCustomNamesPacketCode                         = 12562
+	GetCustomNamesResponsePacketCode                 = GetCustomNamesPacketCode + 1
+	SetCustomNamePacketCode                          = 12564
+	SetCustomNameResponsePacketCode                  = SetCustomNamePacketCode + 1
+	EquipmentConfigurationPacketCode                 = 12566
+	EquipmentConfigurationResponsePacketCode         = EquipmentConfigurationPacketCode + 1
+	CancelDelayPacketCode                            = 12580
+	CancelDelayResponsePacketCode                    = CancelDelayPacketCode + 1
+	GatewayLookupPacketCode                          = 18003
+	GatewayLookupResponsePacketCode                  = GatewayLookupPacketCode + 1
 )
 
 var (
 	MalformedPacketErr = errors.New("malformed packet")
 	LoginFailedErr     = errors.New("login failed")
+	// BadParameterErr - the gateway understood the request, but refused something in it, like a circuit
+	// or body of water that doesn't exist.
+	BadParameterErr = errors.New("gateway rejected a parameter of the request")
 )
 
 type IdentifiablePacket interface {
@@ -171,6 +210,9 @@ type LoginPacket struct {
 	ClientName     string
 	Password       string
 	PID            uint32
+
+	// Challenge is what the gateway replied to the ChallengePacket with, the password is encrypted with it.
+	Challenge string
 }
 
 func (lm *LoginPacket) TypeCode() uint16 {
@@ -210,8 +252,15 @@ func (lm *LoginPacket) Encode() (*bytes.Buffer, error) {
 			return nil, err
 		}
 	} else {
-		// TODO: encrypt password
-		log.Fatal("password encryption not implemented")
+		password, err := EncryptPassword(lm.Password, lm.Challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		err = encoder.WriteString(string(password))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// PID
@@ -231,10 +280,6 @@ func (lm *LoginResponsePacket) TypeCode() uint16 {
 }
 
 func (lrm *LoginResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
-	if header.TypeID == LoginFailedCode {
-		return LoginFailedErr
-	}
-
 	if header.TypeID != LoginResponsePacketCode {
 		return MalformedPacketErr
 	}
@@ -357,10 +402,10 @@ type ControllerConfigurationResponsePacket struct {
 	AllowedPoolSetPointRange SetPoint
 	AllowedSpaSetPointRange  SetPoint
 	IsCelcius                bool
-	ControllerType           uint8
-	HardwareType             uint8
+	ControllerType           ControllerType
+	HardwareType             HardwareType
 	ControllerBuffer         uint8
-	EquipmentFlags           uint32
+	EquipmentFlags           EquipmentFlags
 	DefaultCircuitName       string
 	Circuits                 []ControllerCircuit
 	Colors                   []Color
@@ -373,9 +418,9 @@ type ControllerCircuit struct {
 	ID            uint32
 	Name          string
 	NameIndex     uint8
-	Function      uint8
-	Interface     uint8
-	Flags         uint8
+	Function      CircuitFunction
+	Interface     CircuitInterface
+	Flags         CircuitFlags
 	ColorSet      uint8
 	ColorPosition uint8
 	ColorStagger  uint8
@@ -437,26 +482,32 @@ func (vm *ControllerConfigurationResponsePacket) Decode(header *PacketHeader, bu
 		return err
 	}
 
-	vm.ControllerType, err = decoder.ReadUint8()
+	controllerType, err := decoder.ReadUint8()
 	if err != nil {
 		return err
 	}
 
-	vm.HardwareType, err = decoder.ReadUint8()
+	vm.ControllerType = ControllerType(controllerType)
+
+	hardwareType, err := decoder.ReadUint8()
 	if err != nil {
 		return err
 	}
 
+	vm.HardwareType = HardwareType(hardwareType)
+
 	vm.ControllerBuffer, err = decoder.ReadUint8()
 	if err != nil {
 		return err
 	}
 
-	vm.EquipmentFlags, err = decoder.ReadUint32()
+	equipmentFlags, err := decoder.ReadUint32()
 	if err != nil {
 		return err
 	}
 
+	vm.EquipmentFlags = EquipmentFlags(equipmentFlags)
+
 	vm.DefaultCircuitName, err = decoder.ReadString()
 	if err != nil {
 		return err
@@ -489,21 +540,27 @@ func (vm *ControllerConfigurationResponsePacket) Decode(header *PacketHeader, bu
 				return err
 			}
 
-			circuit.Function, err = decoder.ReadUint8()
+			function, err := decoder.ReadUint8()
 			if err != nil {
 				return err
 			}
 
-			circuit.Interface, err = decoder.ReadUint8()
+			circuit.Function = CircuitFunction(function)
+
+			iface, err := decoder.ReadUint8()
 			if err != nil {
 				return err
 			}
 
-			circuit.Flags, err = decoder.ReadUint8()
+			circuit.Interface = CircuitInterface(iface)
+
+			flags, err := decoder.ReadUint8()
 			if err != nil {
 				return err
 			}
 
+			circuit.Flags = CircuitFlags(flags)
+
 			circuit.ColorSet, err = decoder.ReadUint8()
 			if err != nil {
 				return err
@@ -630,7 +687,7 @@ type PoolStatusResponsePacket struct {
 	Circuits     []PoolCircuit
 	Chemistry    struct {
 		PH           float32
-		ORP          float32
+		ORP          uint32 // millivolts, unlike the other readings it isn't scaled by 100
 		Saturation   float32
 		SaltPPM      uint32
 		PHTankLevel  uint32
@@ -662,7 +719,9 @@ func (psrp *PoolStatusResponsePacket) TypeCode() uint16 {
 }
 
 func (psrp *PoolStatusResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
-	if header.TypeID != PoolStatusResponsePacketCode {
+	// Clients that have been added with AddClientPacket are also pushed this same packet, with
+	// a different type code, whenever the status changes.
+	if header.TypeID != PoolStatusResponsePacketCode && header.TypeID != StatusChangedPacketCode {
 		return MalformedPacketErr
 	}
 
@@ -816,13 +875,11 @@ func (psrp *PoolStatusResponsePacket) Decode(header *PacketHeader, buf *bytes.Bu
 
 	psrp.Chemistry.PH = float32(ph) / 100
 
-	orp, err := decoder.ReadUint32()
+	psrp.Chemistry.ORP, err = decoder.ReadUint32()
 	if err != nil {
 		return err
 	}
 
-	psrp.Chemistry.ORP = float32(orp) / 100
-
 	saturation, err := decoder.ReadUint32()
 	if err != nil {
 		return err
@@ -951,6 +1008,55 @@ func (shmr *SetHeatModeResponsePacket) Decode(header *PacketHeader, buf *bytes.B
 	return nil
 }
 
+type SetCircuitStatePacket struct {
+	ControllerIdx uint32
+	CircuitID     uint32
+	State         uint32
+}
+
+func (scsp *SetCircuitStatePacket) TypeCode() uint16 {
+	return SetCircuitStatePacketCode
+}
+
+func (scsp *SetCircuitStatePacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(scsp.ControllerIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(scsp.CircuitID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(scsp.State)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type SetCircuitStateResponsePacket struct{}
+
+func (scsrp *SetCircuitStateResponsePacket) TypeCode() uint16 {
+	return SetCircuitStateResponsePacketCode
+}
+
+func (scsrp *SetCircuitStateResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != SetCircuitStateResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	// this presumably has no fields?
+
+	return nil
+}
+
 type HistoryPacket struct {
 	ControllerIndex uint32 // use 0
 	Start           time.Time
@@ -1262,3 +1368,1074 @@ func (hrp *HistoryDataResponsePacket) Decode(header *PacketHeader, buf *bytes.Bu
 
 	return nil
 }
+
+type GetScheduleDataPacket struct {
+	ControllerIndex uint32 // use 0
+	ScheduleType    uint32 // 0 for recurring, 1 for run-once
+}
+
+func (gsdp *GetScheduleDataPacket) TypeCode() uint16 {
+	return GetScheduleDataPacketCode
+}
+
+func (gsdp *GetScheduleDataPacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(gsdp.ControllerIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(gsdp.ScheduleType)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type ScheduleEvent struct {
+	ID           uint32
+	CircuitID    uint32
+	StartTime    uint32 // minutes past midnight
+	StopTime     uint32 // minutes past midnight
+	DayMask      uint32
+	Flags        uint32
+	HeatCmd      uint32
+	HeatSetPoint uint32
+}
+
+type GetScheduleDataResponsePacket struct {
+	Events []ScheduleEvent
+}
+
+func (gsdrp *GetScheduleDataResponsePacket) TypeCode() uint16 {
+	return GetScheduleDataResponsePacketCode
+}
+
+func (gsdrp *GetScheduleDataResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != GetScheduleDataResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	decoder := NewDecoder(buf)
+
+	numEvents, err := decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	gsdrp.Events = make([]ScheduleEvent, numEvents)
+
+	for i := uint32(0); i < numEvents; i++ {
+		event := &gsdrp.Events[i]
+
+		event.ID, err = decoder.ReadUint32()
+		if err != nil {
+			return err
+		}
+
+		event.CircuitID, err = decoder.ReadUint32()
+		if err != nil {
+			return err
+		}
+
+		event.StartTime, err = decoder.ReadUint32()
+		if err != nil {
+			return err
+		}
+
+		event.StopTime, err = decoder.ReadUint32()
+		if err != nil {
+			return err
+		}
+
+		event.DayMask, err = decoder.ReadUint32()
+		if err != nil {
+			return err
+		}
+
+		event.Flags, err = decoder.ReadUint32()
+		if err != nil {
+			return err
+		}
+
+		event.HeatCmd, err = decoder.ReadUint32()
+		if err != nil {
+			return err
+		}
+
+		event.HeatSetPoint, err = decoder.ReadUint32()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type AddScheduleEventPacket struct {
+	ControllerIndex uint32 // use 0
+	ScheduleType    uint32 // 0 for recurring, 1 for run-once
+}
+
+func (asep *AddScheduleEventPacket) TypeCode() uint16 {
+	return AddScheduleEventPacketCode
+}
+
+func (asep *AddScheduleEventPacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(asep.ControllerIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(asep.ScheduleType)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// AddScheduleEventResponsePacket - the gateway creates an empty event and hands us back its ID.
+// The event then needs to be filled in with a SetScheduleEventPacket.
+type AddScheduleEventResponsePacket struct {
+	ScheduleID uint32
+}
+
+func (aserp *AddScheduleEventResponsePacket) TypeCode() uint16 {
+	return AddScheduleEventResponsePacketCode
+}
+
+func (aserp *AddScheduleEventResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != AddScheduleEventResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	var err error
+
+	decoder := NewDecoder(buf)
+
+	aserp.ScheduleID, err = decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type DeleteScheduleEventPacket struct {
+	ControllerIndex uint32 // use 0
+	ScheduleID      uint32
+}
+
+func (dsep *DeleteScheduleEventPacket) TypeCode() uint16 {
+	return DeleteScheduleEventPacketCode
+}
+
+func (dsep *DeleteScheduleEventPacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(dsep.ControllerIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(dsep.ScheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type DeleteScheduleEventResponsePacket struct{}
+
+func (dserp *DeleteScheduleEventResponsePacket) TypeCode() uint16 {
+	return DeleteScheduleEventResponsePacketCode
+}
+
+func (dserp *DeleteScheduleEventResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != DeleteScheduleEventResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	// this presumably has no fields?
+
+	return nil
+}
+
+type SetScheduleEventPacket struct {
+	ControllerIndex uint32 // use 0
+	ScheduleEvent
+}
+
+func (ssep *SetScheduleEventPacket) TypeCode() uint16 {
+	return SetScheduleEventPacketCode
+}
+
+func (ssep *SetScheduleEventPacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(ssep.ControllerIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(ssep.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(ssep.CircuitID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(ssep.StartTime)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(ssep.StopTime)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(ssep.DayMask)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(ssep.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(ssep.HeatCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(ssep.HeatSetPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type SetScheduleEventResponsePacket struct{}
+
+func (sserp *SetScheduleEventResponsePacket) TypeCode() uint16 {
+	return SetScheduleEventResponsePacketCode
+}
+
+func (sserp *SetScheduleEventResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != SetScheduleEventResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	// this presumably has no fields?
+
+	return nil
+}
+
+type CancelDelayPacket struct {
+	ControllerIndex uint32 // use 0
+}
+
+func (cdp *CancelDelayPacket) TypeCode() uint16 {
+	return CancelDelayPacketCode
+}
+
+func (cdp *CancelDelayPacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(cdp.ControllerIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type CancelDelayResponsePacket struct{}
+
+func (cdrp *CancelDelayResponsePacket) TypeCode() uint16 {
+	return CancelDelayResponsePacketCode
+}
+
+func (cdrp *CancelDelayResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != CancelDelayResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	return nil
+}
+
+type GetSystemTimePacket struct{}
+
+func (gstp *GetSystemTimePacket) TypeCode() uint16 {
+	return GetSystemTimePacketCode
+}
+
+func (gstp *GetSystemTimePacket) Encode() (*bytes.Buffer, error) {
+	return new(bytes.Buffer), nil
+}
+
+type GetSystemTimeResponsePacket struct {
+	Time         time.Time // in the controller's local time, which we assume matches ours
+	AdjustForDST bool
+}
+
+func (gstrp *GetSystemTimeResponsePacket) TypeCode() uint16 {
+	return GetSystemTimeResponsePacketCode
+}
+
+func (gstrp *GetSystemTimeResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != GetSystemTimeResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	decoder := NewDecoder(buf)
+
+	var err error
+
+	gstrp.Time, err = decoder.ReadDateTime()
+	if err != nil {
+		return err
+	}
+
+	adjustForDST, err := decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	gstrp.AdjustForDST = adjustForDST != 0
+
+	return nil
+}
+
+type SetSystemTimePacket struct {
+	Time         time.Time
+	AdjustForDST bool
+}
+
+func (sstp *SetSystemTimePacket) TypeCode() uint16 {
+	return SetSystemTimePacketCode
+}
+
+func (sstp *SetSystemTimePacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	// The controller has no idea about time zones, it just wants the wall clock time.
+	err := encoder.WriteDateTime(sstp.Time.Local())
+	if err != nil {
+		return nil, err
+	}
+
+	var adjustForDST uint32
+	if sstp.AdjustForDST {
+		adjustForDST = 1
+	}
+
+	err = encoder.WriteUint32(adjustForDST)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type SetSystemTimeResponsePacket struct{}
+
+func (sstrp *SetSystemTimeResponsePacket) TypeCode() uint16 {
+	return SetSystemTimeResponsePacketCode
+}
+
+func (sstrp *SetSystemTimeResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != SetSystemTimeResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	return nil
+}
+
+type WeatherForecastPacket struct{}
+
+func (wfp *WeatherForecastPacket) TypeCode() uint16 {
+	return WeatherForecastPacketCode
+}
+
+func (wfp *WeatherForecastPacket) Encode() (*bytes.Buffer, error) {
+	return new(bytes.Buffer), nil
+}
+
+type WeatherForecastDay struct {
+	Date     time.Time
+	HighTemp int32
+	LowTemp  int32
+}
+
+// WeatherForecastResponsePacket - the forecast the gateway pulls down for the zip code it's configured
+// with. Temperatures are in the controller's units.
+type WeatherForecastResponsePacket struct {
+	Version     uint32
+	ZipCode     string
+	LastUpdate  time.Time
+	LastRequest time.Time
+	DateText    string
+	Text        string
+	CurrentTemp int32
+	Humidity    int32
+	Wind        string
+	Pressure    int32
+	DewPoint    int32
+	WindChill   int32
+	Visibility  int32
+	Days        []WeatherForecastDay
+	Sunrise     uint32 // minutes past midnight
+	Sunset      uint32 // minutes past midnight
+}
+
+func (wfrp *WeatherForecastResponsePacket) TypeCode() uint16 {
+	return WeatherForecastResponsePacketCode
+}
+
+func (wfrp *WeatherForecastResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != WeatherForecastResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	decoder := NewDecoder(buf)
+
+	var err error
+
+	wfrp.Version, err = decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	wfrp.ZipCode, err = decoder.ReadString()
+	if err != nil {
+		return err
+	}
+
+	wfrp.LastUpdate, err = decoder.ReadDateTime()
+	if err != nil {
+		return err
+	}
+
+	wfrp.LastRequest, err = decoder.ReadDateTime()
+	if err != nil {
+		return err
+	}
+
+	wfrp.DateText, err = decoder.ReadString()
+	if err != nil {
+		return err
+	}
+
+	wfrp.Text, err = decoder.ReadString()
+	if err != nil {
+		return err
+	}
+
+	currentTemp, err := decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	wfrp.CurrentTemp = int32(currentTemp)
+
+	humidity, err := decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	wfrp.Humidity = int32(humidity)
+
+	wfrp.Wind, err = decoder.ReadString()
+	if err != nil {
+		return err
+	}
+
+	pressure, err := decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	wfrp.Pressure = int32(pressure)
+
+	dewPoint, err := decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	wfrp.DewPoint = int32(dewPoint)
+
+	windChill, err := decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	wfrp.WindChill = int32(windChill)
+
+	visibility, err := decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	wfrp.Visibility = int32(visibility)
+
+	numDays, err := decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	wfrp.Days = make([]WeatherForecastDay, numDays)
+
+	for i := range wfrp.Days {
+		day := &wfrp.Days[i]
+
+		day.Date, err = decoder.ReadDateTime()
+		if err != nil {
+			return err
+		}
+
+		highTemp, err := decoder.ReadUint32()
+		if err != nil {
+			return err
+		}
+
+		day.HighTemp = int32(highTemp)
+
+		lowTemp, err := decoder.ReadUint32()
+		if err != nil {
+			return err
+		}
+
+		day.LowTemp = int32(lowTemp)
+	}
+
+	wfrp.Sunrise, err = decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	wfrp.Sunset, err = decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddClientPacket - asks the gateway to push status changes (and other updates) to this connection.
+type AddClientPacket struct {
+	ControllerIndex uint32 // use 0
+	ClientID        uint32 // any number, used again to remove the client
+}
+
+func (acp *AddClientPacket) TypeCode() uint16 {
+	return AddClientPacketCode
+}
+
+func (acp *AddClientPacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(acp.ControllerIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(acp.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type AddClientResponsePacket struct{}
+
+func (acrp *AddClientResponsePacket) TypeCode() uint16 {
+	return AddClientResponsePacketCode
+}
+
+func (acrp *AddClientResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != AddClientResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	return nil
+}
+
+type RemoveClientPacket struct {
+	ControllerIndex uint32 // use 0
+	ClientID        uint32
+}
+
+func (rcp *RemoveClientPacket) TypeCode() uint16 {
+	return RemoveClientPacketCode
+}
+
+func (rcp *RemoveClientPacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(rcp.ControllerIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(rcp.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type RemoveClientResponsePacket struct{}
+
+func (rcrp *RemoveClientResponsePacket) TypeCode() uint16 {
+	return RemoveClientResponsePacketCode
+}
+
+func (rcrp *RemoveClientResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != RemoveClientResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	return nil
+}
+
+// ChemistryChangedPacket - pushed when the chemistry controller has new readings. The payload
+// hasn't been worked out yet, so for now it's kept as-is.
+type ChemistryChangedPacket struct {
+	Data []byte
+}
+
+func (ccp *ChemistryChangedPacket) TypeCode() uint16 {
+	return ChemistryChangedPacketCode
+}
+
+func (ccp *ChemistryChangedPacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != ChemistryChangedPacketCode {
+		return MalformedPacketErr
+	}
+
+	ccp.Data = append([]byte(nil), buf.Bytes()...)
+
+	return nil
+}
+
+// ColorUpdatePacket - pushed while color lights are changing, like during a light show sync.
+// The payload hasn't been worked out yet, so for now it's kept as-is.
+type ColorUpdatePacket struct {
+	Data []byte
+}
+
+func (cup *ColorUpdatePacket) TypeCode() uint16 {
+	return ColorUpdatePacketCode
+}
+
+func (cup *ColorUpdatePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != ColorUpdatePacketCode {
+		return MalformedPacketErr
+	}
+
+	cup.Data = append([]byte(nil), buf.Bytes()...)
+
+	return nil
+}
+
+// PingPacket - does nothing but get a reply, which keeps the connection from going idle.
+type PingPacket struct{}
+
+func (pp *PingPacket) TypeCode() uint16 {
+	return PingPacketCode
+}
+
+func (pp *PingPacket) Encode() (*bytes.Buffer, error) {
+	return new(bytes.Buffer), nil
+}
+
+type PingResponsePacket struct{}
+
+func (prp *PingResponsePacket) TypeCode() uint16 {
+	return PingResponsePacketCode
+}
+
+func (prp *PingResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != PingResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	return nil
+}
+
+type EquipmentConfigurationPacket struct {
+	ControllerIndex uint32 // use 0
+	UnknownField    uint32 // always 0
+}
+
+func (ecp *EquipmentConfigurationPacket) TypeCode() uint16 {
+	return EquipmentConfigurationPacketCode
+}
+
+func (ecp *EquipmentConfigurationPacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(ecp.ControllerIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(ecp.UnknownField)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// EquipmentConfigurationResponsePacket - the controller's detailed equipment setup. Past the first few
+// fields this is a series of byte arrays whose layout depends on the controller firmware, so they're kept
+// raw here and interpreted by screenlogic.EquipmentConfiguration.
+type EquipmentConfigurationResponsePacket struct {
+	ControllerType ControllerType
+	HardwareType   HardwareType
+	ControllerData uint32
+
+	VersionData []byte
+	SpeedData   []byte
+	ValveData   []byte
+	RemoteData  []byte
+	SensorData  []byte
+	DelayData   []byte
+	MacroData   []byte
+	MiscData    []byte
+	LightData   []byte
+	FlowData    []byte
+	SGData      []byte
+	SpaFlowData []byte
+}
+
+func (ecrp *EquipmentConfigurationResponsePacket) TypeCode() uint16 {
+	return EquipmentConfigurationResponsePacketCode
+}
+
+func (ecrp *EquipmentConfigurationResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != EquipmentConfigurationResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	var err error
+
+	decoder := NewDecoder(buf)
+
+	controllerType, err := decoder.ReadUint8()
+	if err != nil {
+		return err
+	}
+
+	ecrp.ControllerType = ControllerType(controllerType)
+
+	hardwareType, err := decoder.ReadUint8()
+	if err != nil {
+		return err
+	}
+
+	ecrp.HardwareType = HardwareType(hardwareType)
+
+	// two unused bytes
+	var unused [2]byte
+
+	err = decoder.CopyBytes(unused[:])
+	if err != nil {
+		return err
+	}
+
+	ecrp.ControllerData, err = decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	arrays := []*[]byte{
+		&ecrp.VersionData,
+		&ecrp.SpeedData,
+		&ecrp.ValveData,
+		&ecrp.RemoteData,
+		&ecrp.SensorData,
+		&ecrp.DelayData,
+		&ecrp.MacroData,
+		&ecrp.MiscData,
+		&ecrp.LightData,
+		&ecrp.FlowData,
+		&ecrp.SGData,
+		&ecrp.SpaFlowData,
+	}
+
+	for _, array := range arrays {
+		*array, err = decoder.ReadArray()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type GetCustomNamesPacket struct {
+	ControllerIndex uint32 // use 0
+}
+
+func (gcnp *GetCustomNamesPacket) TypeCode() uint16 {
+	return GetCustomNamesPacketCode
+}
+
+func (gcnp *GetCustomNamesPacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(gcnp.ControllerIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// GetCustomNamesResponsePacket - the table of names the installer has typed in, which circuits refer
+// to by their NameIndex.
+type GetCustomNamesResponsePacket struct {
+	Names []string
+}
+
+func (gcnrp *GetCustomNamesResponsePacket) TypeCode() uint16 {
+	return GetCustomNamesResponsePacketCode
+}
+
+func (gcnrp *GetCustomNamesResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != GetCustomNamesResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	decoder := NewDecoder(buf)
+
+	count, err := decoder.ReadUint32()
+	if err != nil {
+		return err
+	}
+
+	gcnrp.Names = make([]string, count)
+
+	for i := range gcnrp.Names {
+		gcnrp.Names[i], err = decoder.ReadString()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type SetCustomNamePacket struct {
+	ControllerIndex uint32 // use 0
+	Index           uint32
+	Name            string
+}
+
+func (scnp *SetCustomNamePacket) TypeCode() uint16 {
+	return SetCustomNamePacketCode
+}
+
+func (scnp *SetCustomNamePacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(scnp.ControllerIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(scnp.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteString(scnp.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type SetCustomNameResponsePacket struct{}
+
+func (scnrp *SetCustomNameResponsePacket) TypeCode() uint16 {
+	return SetCustomNameResponsePacketCode
+}
+
+func (scnrp *SetCustomNameResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != SetCustomNameResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	return nil
+}
+
+type SetCircuitRuntimePacket struct {
+	ControllerIdx uint32
+	CircuitID     uint32
+	Runtime       uint32 // minutes
+}
+
+func (scrp *SetCircuitRuntimePacket) TypeCode() uint16 {
+	return SetCircuitRuntimePacketCode
+}
+
+func (scrp *SetCircuitRuntimePacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	err := encoder.WriteUint32(scrp.ControllerIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(scrp.CircuitID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteUint32(scrp.Runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type SetCircuitRuntimeResponsePacket struct{}
+
+func (scrrp *SetCircuitRuntimeResponsePacket) TypeCode() uint16 {
+	return SetCircuitRuntimeResponsePacketCode
+}
+
+func (scrrp *SetCircuitRuntimeResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != SetCircuitRuntimeResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	return nil
+}
+
+// GatewayLookupPacket - asks the dispatcher where a gateway can be reached from the internet.
+type GatewayLookupPacket struct {
+	GatewayName string // like "Pentair: 01-23-45"
+}
+
+func (glp *GatewayLookupPacket) TypeCode() uint16 {
+	return GatewayLookupPacketCode
+}
+
+func (glp *GatewayLookupPacket) Encode() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	encoder := NewEncoder(buf)
+
+	// The name is sent twice, nobody seems to know why.
+	err := encoder.WriteString(glp.GatewayName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.WriteString(glp.GatewayName)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type GatewayLookupResponsePacket struct {
+	GatewayFound bool
+	LicenseOK    bool
+	IPAddr       string
+	Port         uint16
+	PortOpen     bool
+	RelayOn      bool
+}
+
+func (glrp *GatewayLookupResponsePacket) TypeCode() uint16 {
+	return GatewayLookupResponsePacketCode
+}
+
+func (glrp *GatewayLookupResponsePacket) Decode(header *PacketHeader, buf *bytes.Buffer) error {
+	if header.TypeID != GatewayLookupResponsePacketCode {
+		return MalformedPacketErr
+	}
+
+	var err error
+
+	decoder := NewDecoder(buf)
+
+	glrp.GatewayFound, err = decoder.ReadBool()
+	if err != nil {
+		return err
+	}
+
+	glrp.LicenseOK, err = decoder.ReadBool()
+	if err != nil {
+		return err
+	}
+
+	glrp.IPAddr, err = decoder.ReadString()
+	if err != nil {
+		return err
+	}
+
+	glrp.Port, err = decoder.ReadUint16()
+	if err != nil {
+		return err
+	}
+
+	glrp.PortOpen, err = decoder.ReadBool()
+	if err != nil {
+		return err
+	}
+
+	glrp.RelayOn, err = decoder.ReadBool()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,630 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writePacket - writes p the way it goes over the wire, and returns a Decoder for its body as the gateway
+// would see it.
+func writePacket(t *testing.T, p WriteablePacket) *Decoder {
+	t.Helper()
+
+	var wire bytes.Buffer
+
+	err := NewPacketWriter(&wire, 7).WritePacket(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header PacketHeader
+
+	err = binary.Read(&wire, binary.LittleEndian, &header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.TypeID != p.TypeCode() || header.Sequence != 7 {
+		t.Fatalf("header is %+v, want type %d and sequence 7", header, p.TypeCode())
+	}
+
+	if int(header.Len) != wire.Len() {
+		t.Fatalf("header says the body is %d bytes, but it's %d", header.Len, wire.Len())
+	}
+
+	return NewDecoder(&wire)
+}
+
+// readPacket - frames the body written by fn with typeCode, and reads it into p with a PacketReader.
+func readPacket(t *testing.T, typeCode uint16, fn func(e *Encoder), p ReadablePacket) error {
+	t.Helper()
+
+	var body bytes.Buffer
+
+	if fn != nil {
+		fn(NewEncoder(&body))
+	}
+
+	var wire bytes.Buffer
+
+	err := binary.Write(&wire, binary.LittleEndian, PacketHeader{TypeID: typeCode, Len: uint32(body.Len())})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body.WriteTo(&wire)
+
+	return NewPacketReader(&wire, nil).ReadPacket(p)
+}
+
+// readUint32s - reads len(want) uint32s from d and fails unless they match want and nothing is left over.
+func readUint32s(t *testing.T, d *Decoder, want ...uint32) {
+	t.Helper()
+
+	got := make([]uint32, len(want))
+
+	for i := range got {
+		var err error
+
+		got[i], err = d.ReadUint32()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("body is %v, want %v", got, want)
+	}
+
+	assertEmpty(t, d)
+}
+
+func assertEmpty(t *testing.T, d *Decoder) {
+	t.Helper()
+
+	tail, _ := d.ReadTail()
+	if len(tail) > 0 {
+		t.Errorf("%d bytes left over: %x", len(tail), tail)
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "a", "ab", "abc", "Pool", "Spa 2", "Pool Light"} {
+		var buf bytes.Buffer
+
+		err := NewEncoder(&buf).WriteString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.Len()%4 != 0 {
+			t.Errorf("%q was encoded to %d bytes, which isn't padded to 4", s, buf.Len())
+		}
+
+		decoder := NewDecoder(&buf)
+
+		got, err := decoder.ReadString()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != s {
+			t.Errorf("got %q, want %q", got, s)
+		}
+
+		assertEmpty(t, decoder)
+	}
+}
+
+func TestDateTimeRoundTrip(t *testing.T) {
+	want := time.Date(2026, 3, 8, 14, 30, 15, 250*int(time.Millisecond), time.Local)
+
+	var buf bytes.Buffer
+
+	err := NewEncoder(&buf).WriteDateTime(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewDecoder(&buf).ReadDateTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRequestEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet WriteablePacket
+		want   []uint32
+	}{
+		{"get schedules", &GetScheduleDataPacket{ScheduleType: 1}, []uint32{0, 1}},
+		{"add schedule", &AddScheduleEventPacket{ScheduleType: 0}, []uint32{0, 0}},
+		{"delete schedule", &DeleteScheduleEventPacket{ScheduleID: 702}, []uint32{0, 702}},
+		{
+			"update schedule",
+			&SetScheduleEventPacket{ScheduleEvent: ScheduleEvent{
+				ID: 702, CircuitID: 505, StartTime: 8 * 60, StopTime: 17 * 60, DayMask: 0x7f, Flags: 2, HeatCmd: 3, HeatSetPoint: 84,
+			}},
+			[]uint32{0, 702, 505, 8 * 60, 17 * 60, 0x7f, 2, 3, 84},
+		},
+		{"set circuit runtime", &SetCircuitRuntimePacket{CircuitID: 500, Runtime: 90}, []uint32{0, 500, 90}},
+		{"get custom names", &GetCustomNamesPacket{}, []uint32{0}},
+		{"equipment config", &EquipmentConfigurationPacket{}, []uint32{0, 0}},
+		{"cancel delay", &CancelDelayPacket{}, []uint32{0}},
+		{"add client", &AddClientPacket{ClientID: 4321}, []uint32{0, 4321}},
+		{"remove client", &RemoveClientPacket{ClientID: 4321}, []uint32{0, 4321}},
+		{"ping", &PingPacket{}, []uint32{}},
+		{"get system time", &GetSystemTimePacket{}, []uint32{}},
+		{"weather forecast", &WeatherForecastPacket{}, []uint32{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readUint32s(t, writePacket(t, tt.packet), tt.want...)
+		})
+	}
+}
+
+func TestHistoryPacketEncoding(t *testing.T) {
+	start := time.Date(2026, 7, 1, 0, 0, 0, 0, time.Local)
+	end := start.Add(24 * time.Hour)
+
+	d := writePacket(t, &HistoryPacket{Start: start, End: end})
+
+	controllerIndex, _ := d.ReadUint32()
+	gotStart, _ := d.ReadDateTime()
+	gotEnd, _ := d.ReadDateTime()
+	senderID, err := d.ReadUint32()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if controllerIndex != 0 || !gotStart.Equal(start) || !gotEnd.Equal(end) || senderID != 0 {
+		t.Errorf("got %d, %v, %v, %d", controllerIndex, gotStart, gotEnd, senderID)
+	}
+
+	assertEmpty(t, d)
+}
+
+func TestSetSystemTimePacketEncoding(t *testing.T) {
+	now := time.Date(2026, 11, 1, 1, 59, 30, 0, time.Local)
+
+	d := writePacket(t, &SetSystemTimePacket{Time: now, AdjustForDST: true})
+
+	got, _ := d.ReadDateTime()
+	adjustForDST, err := d.ReadUint32()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Equal(now) || adjustForDST != 1 {
+		t.Errorf("got %v, %d", got, adjustForDST)
+	}
+
+	assertEmpty(t, d)
+}
+
+func TestSetCustomNamePacketEncoding(t *testing.T) {
+	// Names that are already a multiple of 4 long don't get any padding.
+	for _, name := range []string{"Deck", "Waterfall"} {
+		d := writePacket(t, &SetCustomNamePacket{Index: 3, Name: name})
+
+		controllerIndex, _ := d.ReadUint32()
+		index, _ := d.ReadUint32()
+		got, err := d.ReadString()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if controllerIndex != 0 || index != 3 || got != name {
+			t.Errorf("got %d, %d, %q", controllerIndex, index, got)
+		}
+
+		assertEmpty(t, d)
+	}
+}
+
+func TestGatewayLookupPacketEncoding(t *testing.T) {
+	d := writePacket(t, &GatewayLookupPacket{GatewayName: "Pentair: 01-23-45"})
+
+	first, _ := d.ReadString()
+	second, err := d.ReadString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != "Pentair: 01-23-45" || second != first {
+		t.Errorf("got %q and %q", first, second)
+	}
+
+	assertEmpty(t, d)
+}
+
+func TestLoginPacketEncoding(t *testing.T) {
+	d := writePacket(t, &LoginPacket{Schema: 348, ClientName: "homekit", PID: 2})
+
+	schema, _ := d.ReadUint32()
+	connectionType, _ := d.ReadUint32()
+	clientName, _ := d.ReadString()
+	password, _ := d.ReadString()
+	pid, err := d.ReadUint32()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if schema != 348 || connectionType != 0 || clientName != "homekit" || password != string(make([]byte, 16)) || pid != 2 {
+		t.Errorf("got %d, %d, %q, %x, %d", schema, connectionType, clientName, password, pid)
+	}
+
+	assertEmpty(t, d)
+}
+
+func TestScheduleDataResponseDecoding(t *testing.T) {
+	want := []ScheduleEvent{
+		{ID: 700, CircuitID: 505, StartTime: 480, StopTime: 1020, DayMask: 0x7f, Flags: 0, HeatCmd: 0, HeatSetPoint: 0},
+		{ID: 701, CircuitID: 500, StartTime: 1200, StopTime: 1260, DayMask: 0x41, Flags: 2, HeatCmd: 3, HeatSetPoint: 102},
+	}
+
+	var p GetScheduleDataResponsePacket
+
+	err := readPacket(t, GetScheduleDataResponsePacketCode, func(e *Encoder) {
+		e.WriteUint32(uint32(len(want)))
+
+		for _, event := range want {
+			for _, v := range []uint32{event.ID, event.CircuitID, event.StartTime, event.StopTime, event.DayMask, event.Flags, event.HeatCmd, event.HeatSetPoint} {
+				e.WriteUint32(v)
+			}
+		}
+	}, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(p.Events, want) {
+		t.Errorf("got %+v, want %+v", p.Events, want)
+	}
+}
+
+func TestAddScheduleEventResponseDecoding(t *testing.T) {
+	var p AddScheduleEventResponsePacket
+
+	err := readPacket(t, AddScheduleEventResponsePacketCode, func(e *Encoder) {
+		e.WriteUint32(703)
+	}, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.ScheduleID != 703 {
+		t.Errorf("got schedule %d, want 703", p.ScheduleID)
+	}
+}
+
+func TestHistoryDataResponseDecoding(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2026, 7, 4, hour, 0, 0, 0, time.Local)
+	}
+
+	temps := func(e *Encoder, events []HistoryEvent) {
+		e.WriteUint32(uint32(len(events)))
+
+		for _, event := range events {
+			e.WriteDateTime(event.Timestamp)
+			e.WriteUint32(event.Temp)
+		}
+	}
+
+	runs := func(e *Encoder, events []StartStopEvent) {
+		e.WriteUint32(uint32(len(events)))
+
+		for _, event := range events {
+			e.WriteDateTime(event.Start)
+			e.WriteDateTime(event.Stop)
+		}
+	}
+
+	want := HistoryDataResponsePacket{
+		OutsideTemps:     []HistoryEvent{{at(1), 70}, {at(2), 68}},
+		PoolWaterTemps:   []HistoryEvent{{at(1), 82}},
+		HotTubWaterTemps: []HistoryEvent{{at(3), 101}},
+		PoolRuns:         []StartStopEvent{{at(8), at(17)}},
+		HotTubRuns:       []StartStopEvent{},
+		SolarRuns:        []StartStopEvent{{at(10), at(15)}},
+		HeaterRuns:       []StartStopEvent{},
+		LightRuns:        []StartStopEvent{{at(20), at(23)}},
+	}
+
+	var p HistoryDataResponsePacket
+
+	err := readPacket(t, HistoryDataResponsePacketCode, func(e *Encoder) {
+		temps(e, want.OutsideTemps)
+		temps(e, want.PoolWaterTemps)
+		temps(e, []HistoryEvent{{at(1), 84}}) // pool set points, skipped
+		temps(e, want.HotTubWaterTemps)
+		temps(e, []HistoryEvent{{at(3), 102}}) // spa set points, skipped
+		runs(e, want.PoolRuns)
+		runs(e, want.HotTubRuns)
+		runs(e, want.SolarRuns)
+		runs(e, want.HeaterRuns)
+		runs(e, want.LightRuns)
+	}, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+}
+
+func TestGetSystemTimeResponseDecoding(t *testing.T) {
+	want := time.Date(2026, 3, 8, 2, 30, 0, 0, time.Local)
+
+	var p GetSystemTimeResponsePacket
+
+	err := readPacket(t, GetSystemTimeResponsePacketCode, func(e *Encoder) {
+		e.WriteDateTime(want)
+		e.WriteUint32(1)
+	}, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.Time.Equal(want) || !p.AdjustForDST {
+		t.Errorf("got %v, %v", p.Time, p.AdjustForDST)
+	}
+}
+
+func TestWeatherForecastResponseDecoding(t *testing.T) {
+	day := time.Date(2026, 7, 4, 0, 0, 0, 0, time.Local)
+
+	want := WeatherForecastResponsePacket{
+		Version:     2,
+		ZipCode:     "94107",
+		LastUpdate:  day.Add(9 * time.Hour),
+		LastRequest: day.Add(9*time.Hour + 5*time.Minute),
+		DateText:    "Sat, Jul 4",
+		Text:        "Fog",
+		CurrentTemp: -3,
+		Humidity:    80,
+		Wind:        "W 12",
+		Pressure:    30,
+		DewPoint:    50,
+		WindChill:   -10,
+		Visibility:  2,
+		Days: []WeatherForecastDay{
+			{Date: day, HighTemp: 68, LowTemp: 55},
+			{Date: day.AddDate(0, 0, 1), HighTemp: 70, LowTemp: -1},
+		},
+		Sunrise: 6*60 + 2,
+		Sunset:  20*60 + 35,
+	}
+
+	var p WeatherForecastResponsePacket
+
+	err := readPacket(t, WeatherForecastResponsePacketCode, func(e *Encoder) {
+		e.WriteUint32(want.Version)
+		e.WriteString(want.ZipCode)
+		e.WriteDateTime(want.LastUpdate)
+		e.WriteDateTime(want.LastRequest)
+		e.WriteString(want.DateText)
+		e.WriteString(want.Text)
+		e.WriteUint32(uint32(want.CurrentTemp))
+		e.WriteUint32(uint32(want.Humidity))
+		e.WriteString(want.Wind)
+		e.WriteUint32(uint32(want.Pressure))
+		e.WriteUint32(uint32(want.DewPoint))
+		e.WriteUint32(uint32(want.WindChill))
+		e.WriteUint32(uint32(want.Visibility))
+		e.WriteUint32(uint32(len(want.Days)))
+
+		for _, day := range want.Days {
+			e.WriteDateTime(day.Date)
+			e.WriteUint32(uint32(day.HighTemp))
+			e.WriteUint32(uint32(day.LowTemp))
+		}
+
+		e.WriteUint32(want.Sunrise)
+		e.WriteUint32(want.Sunset)
+	}, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+}
+
+func TestEquipmentConfigurationResponseDecoding(t *testing.T) {
+	want := EquipmentConfigurationResponsePacket{
+		ControllerType: ControllerTypeIntelliTouchDualBody,
+		HardwareType:   1,
+		ControllerData: 0x02,
+		VersionData:    []byte{1, 2, 3},
+		SpeedData:      []byte{},
+		ValveData:      []byte{1, 0, 0, 0, 0, 1, 2, 3, 4},
+		RemoteData:     []byte{},
+		SensorData:     []byte{9, 9, 9, 9},
+		DelayData:      []byte{0x1},
+		MacroData:      []byte{},
+		MiscData:       []byte{0, 1},
+		LightData:      []byte{},
+		FlowData:       []byte{},
+		SGData:         []byte{},
+		SpaFlowData:    []byte{5},
+	}
+
+	var p EquipmentConfigurationResponsePacket
+
+	err := readPacket(t, EquipmentConfigurationResponsePacketCode, func(e *Encoder) {
+		e.WriteUint8(uint8(want.ControllerType))
+		e.WriteUint8(uint8(want.HardwareType))
+		e.WriteUint16(0)
+		e.WriteUint32(want.ControllerData)
+
+		for _, array := range [][]byte{
+			want.VersionData, want.SpeedData, want.ValveData, want.RemoteData, want.SensorData, want.DelayData,
+			want.MacroData, want.MiscData, want.LightData, want.FlowData, want.SGData, want.SpaFlowData,
+		} {
+			e.WriteString(string(array))
+		}
+	}, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+}
+
+func TestGetCustomNamesResponseDecoding(t *testing.T) {
+	want := []string{"Deck Jets", "Bug", "", "Waterfall"}
+
+	var p GetCustomNamesResponsePacket
+
+	err := readPacket(t, GetCustomNamesResponsePacketCode, func(e *Encoder) {
+		e.WriteUint32(uint32(len(want)))
+
+		for _, name := range want {
+			e.WriteString(name)
+		}
+	}, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(p.Names, want) {
+		t.Errorf("got %q, want %q", p.Names, want)
+	}
+}
+
+func TestGatewayLookupResponseDecoding(t *testing.T) {
+	want := GatewayLookupResponsePacket{
+		GatewayFound: true,
+		LicenseOK:    true,
+		IPAddr:       "203.0.113.5",
+		Port:         500,
+		PortOpen:     true,
+		RelayOn:      false,
+	}
+
+	var p GatewayLookupResponsePacket
+
+	err := readPacket(t, GatewayLookupResponsePacketCode, func(e *Encoder) {
+		e.WriteUint8(1)
+		e.WriteUint8(1)
+		e.WriteString(want.IPAddr)
+		e.WriteUint16(want.Port)
+		e.WriteUint8(1)
+		e.WriteUint8(0)
+	}, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p != want {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+}
+
+func TestOOBPacketDecoding(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	var chemistry ChemistryChangedPacket
+
+	err := readPacket(t, ChemistryChangedPacketCode, func(e *Encoder) { e.WriteBytes(data) }, &chemistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var color ColorUpdatePacket
+
+	err = readPacket(t, ColorUpdatePacketCode, func(e *Encoder) { e.WriteBytes(data) }, &color)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(chemistry.Data, data) || !bytes.Equal(color.Data, data) {
+		t.Errorf("got %x and %x, want %x", chemistry.Data, color.Data, data)
+	}
+}
+
+// Replies with no body all come down to checking the type code.
+func TestEmptyResponseDecoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet ReadablePacket
+	}{
+		{"delete schedule", &DeleteScheduleEventResponsePacket{}},
+		{"update schedule", &SetScheduleEventResponsePacket{}},
+		{"history", &HistoryResponsePacket{}},
+		{"cancel delay", &CancelDelayResponsePacket{}},
+		{"set system time", &SetSystemTimeResponsePacket{}},
+		{"add client", &AddClientResponsePacket{}},
+		{"remove client", &RemoveClientResponsePacket{}},
+		{"ping", &PingResponsePacket{}},
+		{"set custom name", &SetCustomNameResponsePacket{}},
+		{"set circuit runtime", &SetCircuitRuntimeResponsePacket{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := readPacket(t, tt.packet.TypeCode(), nil, tt.packet)
+			if err != nil {
+				t.Errorf("unable to read reply: %v", err)
+			}
+
+			err = tt.packet.Decode(&PacketHeader{TypeID: tt.packet.TypeCode() + 1}, new(bytes.Buffer))
+			if err != MalformedPacketErr {
+				t.Errorf("reply with the wrong type code gave %v, want MalformedPacketErr", err)
+			}
+		})
+	}
+}
+
+// Error replies answer the request itself, so they're returned from ReadPacket instead of being handed
+// over as out of band packets or decoded as the reply.
+func TestErrorReplies(t *testing.T) {
+	tests := []struct {
+		name     string
+		typeCode uint16
+		packet   ReadablePacket
+		want     error
+	}{
+		{"login failed", LoginFailedCode, &LoginResponsePacket{}, LoginFailedErr},
+		{"bad parameter", BadParameterCode, &SetCircuitStateResponsePacket{}, BadParameterErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wire bytes.Buffer
+
+			binary.Write(&wire, binary.LittleEndian, PacketHeader{TypeID: tt.typeCode})
+
+			oob := false
+
+			err := NewPacketReader(&wire, func(header *PacketHeader, data *bytes.Buffer) error {
+				oob = true
+				return nil
+			}).ReadPacket(tt.packet)
+
+			if err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+
+			if oob {
+				t.Error("error reply was handed over as an out of band packet")
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"crypto/aes"
+	"errors"
+)
+
+var MissingChallengeErr = errors.New("a password can't be sent without the gateway's challenge")
+
+// EncryptPassword - remote connections send the password encrypted with the challenge string the gateway
+// replied to the ChallengePacket with (its MAC address) as the key. It's AES in ECB mode, with the key
+// zero padded to 32 bytes and the password zero padded to a whole number of blocks.
+func EncryptPassword(password string, challenge string) ([]byte, error) {
+	if challenge == "" {
+		return nil, MissingChallengeErr
+	}
+
+	key := make([]byte, 32)
+	copy(key, challenge)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	size := (len(password) + aes.BlockSize - 1) / aes.BlockSize * aes.BlockSize
+	if size == 0 {
+		size = aes.BlockSize
+	}
+
+	data := make([]byte, size)
+	copy(data, password)
+
+	for i := 0; i < len(data); i += aes.BlockSize {
+		block.Encrypt(data[i:i+aes.BlockSize], data[i:i+aes.BlockSize])
+	}
+
+	return data, nil
+}
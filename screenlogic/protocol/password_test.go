@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// The expected values come from openssl enc -aes-256-ecb -nopad, with the same key and zero padding.
+func TestEncryptPassword(t *testing.T) {
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{"secret", "0ab234aae042f616b90db8b96c7fb34b"},
+		{"a password that is long", "5055556f0c165c3bef71eb9e20382f22239b5c5b5bfee9259b0abb541450f9e1"},
+	}
+
+	for _, tt := range tests {
+		got, err := EncryptPassword(tt.password, "00-11-22-33-44-55")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, _ := hex.DecodeString(tt.want)
+
+		if !bytes.Equal(got, want) {
+			t.Errorf("EncryptPassword(%q) = %x, want %s", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestEncryptPasswordWithoutChallenge(t *testing.T) {
+	_, err := EncryptPassword("secret", "")
+	if err != MissingChallengeErr {
+		t.Errorf("got %v, want MissingChallengeErr", err)
+	}
+}
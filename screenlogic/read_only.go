@@ -0,0 +1,40 @@
+package screenlogic
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+var ReadOnlyErr = errors.New("gateway is read-only")
+
+// Every request known not to change anything on the controller. When Gateway.ReadOnly is set, anything
+// else is refused, so a new request can't slip through by being left off a list.
+//
+// Adding and removing clients only changes which connections the gateway pushes updates to.
+var readOnlyPacketCodes = map[uint16]bool{
+	protocol.ChallengePacketCode:               true,
+	protocol.PingPacketCode:                    true,
+	protocol.LoginPacketCode:                   true,
+	protocol.GetSystemTimePacketCode:           true,
+	protocol.VersionPacketCode:                 true,
+	protocol.WeatherForecastPacketCode:         true,
+	protocol.AddClientPacketCode:               true,
+	protocol.RemoveClientPacketCode:            true,
+	protocol.ControllerConfigurationPacketCode: true,
+	protocol.PoolStatusPacketCode:              true,
+	protocol.HistoryPacketCode:                 true,
+	protocol.GetScheduleDataPacketCode:         true,
+	protocol.GetCustomNamesPacketCode:          true,
+	protocol.EquipmentConfigurationPacketCode:  true,
+}
+
+// checkWritable - refuses req if g is read-only, unless req is known not to change anything.
+func (g *Gateway) checkWritable(req protocol.WriteablePacket) error {
+	if !g.ReadOnly || readOnlyPacketCodes[req.TypeCode()] {
+		return nil
+	}
+
+	return fmt.Errorf("%w, refusing request with type code %d", ReadOnlyErr, req.TypeCode())
+}
@@ -0,0 +1,58 @@
+package screenlogic
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+// unknownPacket - stands in for a request nobody has looked at yet.
+type unknownPacket struct{}
+
+func (up *unknownPacket) TypeCode() uint16 {
+	return 65000
+}
+
+func (up *unknownPacket) Encode() (*bytes.Buffer, error) {
+	return nil, nil
+}
+
+func TestCheckWritable(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     protocol.WriteablePacket
+		allowed bool
+	}{
+		{"pool status", &protocol.PoolStatusPacket{}, true},
+		{"ping", &protocol.PingPacket{}, true},
+		{"login", &protocol.LoginPacket{}, true},
+		{"set heat point", &protocol.SetHeatPointPacket{}, false},
+		{"set circuit state", &protocol.SetCircuitStatePacket{}, false},
+		{"set custom name", &protocol.SetCustomNamePacket{}, false},
+		{"unknown request", &unknownPacket{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Gateway{ReadOnly: true}
+
+			err := g.checkWritable(tt.req)
+			if tt.allowed && err != nil {
+				t.Errorf("refused: %v", err)
+			}
+
+			if !tt.allowed && !errors.Is(err, ReadOnlyErr) {
+				t.Errorf("got %v, want ReadOnlyErr", err)
+			}
+
+			g.ReadOnly = false
+
+			err = g.checkWritable(tt.req)
+			if err != nil {
+				t.Errorf("refused without ReadOnly: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package screenlogic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+// DefaultDispatcherAddress - Pentair's dispatcher, which keeps track of where every gateway with remote
+// access turned on can be reached from the internet.
+const DefaultDispatcherAddress = "screenlogicserver.pentair.com:500"
+
+const dispatcherTimeout = 10 * time.Second
+
+var GatewayNotFoundErr = errors.New("gateway not found by the dispatcher")
+
+// LookupRemoteGateway - asks the dispatcher at dispatcherAddr (host:port) where the gateway called gatewayName
+// (like "Pentair: 01-23-45") can be reached, for connecting to it from outside its network. Remote connections
+// need the gateway's password set before logging in.
+func LookupRemoteGateway(dispatcherAddr string, gatewayName string) (*Gateway, error) {
+	conn, err := net.DialTimeout("tcp4", dispatcherAddr, dispatcherTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(dispatcherTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	// The dispatcher never sends anything we didn't ask for.
+	reader := protocol.NewPacketReader(conn, nil)
+	writer := protocol.NewPacketWriter(conn, 0)
+
+	req := protocol.GatewayLookupPacket{GatewayName: gatewayName}
+
+	var resp protocol.GatewayLookupResponsePacket
+
+	err = writer.WritePacket(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = reader.ReadPacket(&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.GatewayFound {
+		return nil, GatewayNotFoundErr
+	}
+
+	if !resp.PortOpen {
+		// The gateway can also be reached through Pentair's relay in this case, but that isn't supported.
+		return nil, fmt.Errorf("%s isn't reachable from the internet, its port %d is closed", gatewayName, resp.Port)
+	}
+
+	ip := net.ParseIP(resp.IPAddr)
+	if ip == nil {
+		return nil, fmt.Errorf("dispatcher returned an invalid address %q for %s", resp.IPAddr, gatewayName)
+	}
+
+	return &Gateway{
+		IP:   ip,
+		Port: resp.Port,
+		Name: gatewayName,
+	}, nil
+}
@@ -0,0 +1,119 @@
+package screenlogic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+// fakeDispatcher - answers a single gateway lookup with resp, and sends the name it was asked for on names.
+func fakeDispatcher(t *testing.T, resp func(e *protocol.Encoder)) (string, <-chan string) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	names := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var header protocol.PacketHeader
+
+		err = binary.Read(conn, binary.LittleEndian, &header)
+		if err != nil || header.TypeID != protocol.GatewayLookupPacketCode {
+			return
+		}
+
+		body := make([]byte, header.Len)
+
+		_, err = io.ReadFull(conn, body)
+		if err != nil {
+			return
+		}
+
+		name, _ := protocol.NewDecoder(bytes.NewBuffer(body)).ReadString()
+		names <- name
+
+		var data bytes.Buffer
+		resp(protocol.NewEncoder(&data))
+
+		binary.Write(conn, binary.LittleEndian, protocol.PacketHeader{
+			Sequence: header.Sequence,
+			TypeID:   protocol.GatewayLookupResponsePacketCode,
+			Len:      uint32(data.Len()),
+		})
+		data.WriteTo(conn)
+	}()
+
+	return listener.Addr().String(), names
+}
+
+func TestLookupRemoteGateway(t *testing.T) {
+	addr, names := fakeDispatcher(t, func(e *protocol.Encoder) {
+		e.WriteUint8(1) // found
+		e.WriteUint8(1) // license ok
+		e.WriteString("203.0.113.5")
+		e.WriteUint16(500)
+		e.WriteUint8(1) // port open
+		e.WriteUint8(0) // relay on
+	})
+
+	g, err := LookupRemoteGateway(addr, "Pentair: 01-23-45")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := <-names; name != "Pentair: 01-23-45" {
+		t.Errorf("dispatcher was asked for %q", name)
+	}
+
+	if !g.IP.Equal(net.ParseIP("203.0.113.5")) || g.Port != 500 || g.Name != "Pentair: 01-23-45" {
+		t.Errorf("got %v:%d %q", g.IP, g.Port, g.Name)
+	}
+}
+
+func TestLookupRemoteGatewayNotFound(t *testing.T) {
+	addr, _ := fakeDispatcher(t, func(e *protocol.Encoder) {
+		e.WriteUint8(0)
+		e.WriteUint8(1)
+		e.WriteString("")
+		e.WriteUint16(0)
+		e.WriteUint8(0)
+		e.WriteUint8(0)
+	})
+
+	_, err := LookupRemoteGateway(addr, "Pentair: 01-23-45")
+	if !errors.Is(err, GatewayNotFoundErr) {
+		t.Errorf("got %v, want GatewayNotFoundErr", err)
+	}
+}
+
+func TestLookupRemoteGatewayPortClosed(t *testing.T) {
+	addr, _ := fakeDispatcher(t, func(e *protocol.Encoder) {
+		e.WriteUint8(1)
+		e.WriteUint8(1)
+		e.WriteString("203.0.113.5")
+		e.WriteUint16(500)
+		e.WriteUint8(0)
+		e.WriteUint8(1)
+	})
+
+	_, err := LookupRemoteGateway(addr, "Pentair: 01-23-45")
+	if err == nil {
+		t.Error("a gateway with its port closed was returned")
+	}
+}
@@ -0,0 +1,102 @@
+package screenlogic
+
+import (
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+type ScheduleType uint32
+
+const (
+	ScheduleTypeRecurring ScheduleType = iota
+	ScheduleTypeRunOnce
+)
+
+// ScheduleDays - bitmask of the days a schedule runs on. The controller starts the week on Monday.
+type ScheduleDays uint32
+
+const (
+	ScheduleMonday ScheduleDays = 1 << iota
+	ScheduleTuesday
+	ScheduleWednesday
+	ScheduleThursday
+	ScheduleFriday
+	ScheduleSaturday
+	ScheduleSunday
+
+	ScheduleWeekdays = ScheduleMonday | ScheduleTuesday | ScheduleWednesday | ScheduleThursday | ScheduleFriday
+	ScheduleWeekends = ScheduleSaturday | ScheduleSunday
+	ScheduleEveryDay = ScheduleWeekdays | ScheduleWeekends
+)
+
+func (sd ScheduleDays) Includes(day time.Weekday) bool {
+	// time.Weekday starts on Sunday, the controller starts on Monday.
+	bit := (uint(day) + 6) % 7
+
+	return (sd & (1 << bit)) != 0
+}
+
+const (
+	scheduleFlagRunOnce      = 0x1
+	scheduleFlagHeatSetPoint = 0x2
+)
+
+type Schedule struct {
+	ID        uint32
+	Type      ScheduleType
+	CircuitID uint32
+	Days      ScheduleDays
+
+	// Start and Stop are offsets from midnight, with minute resolution.
+	Start time.Duration
+	Stop  time.Duration
+
+	// If UpdateHeatSetPoint is true, the controller will also apply HeatMode and HeatSetPoint
+	// to the body of water attached to the circuit when this schedule starts.
+	UpdateHeatSetPoint bool
+	HeatMode           HeatMode
+	HeatSetPoint       uint32
+}
+
+func newScheduleFromEvent(event *protocol.ScheduleEvent) Schedule {
+	schedule := Schedule{
+		ID:                 event.ID,
+		Type:               ScheduleTypeRecurring,
+		CircuitID:          event.CircuitID,
+		Days:               ScheduleDays(event.DayMask),
+		Start:              time.Duration(event.StartTime) * time.Minute,
+		Stop:               time.Duration(event.StopTime) * time.Minute,
+		UpdateHeatSetPoint: (event.Flags & scheduleFlagHeatSetPoint) != 0,
+		HeatMode:           HeatMode(event.HeatCmd),
+		HeatSetPoint:       event.HeatSetPoint,
+	}
+
+	if (event.Flags & scheduleFlagRunOnce) != 0 {
+		schedule.Type = ScheduleTypeRunOnce
+	}
+
+	return schedule
+}
+
+func (s *Schedule) event() protocol.ScheduleEvent {
+	event := protocol.ScheduleEvent{
+		ID:           s.ID,
+		CircuitID:    s.CircuitID,
+		StartTime:    uint32(s.Start / time.Minute),
+		StopTime:     uint32(s.Stop / time.Minute),
+		DayMask:      uint32(s.Days),
+		HeatCmd:      uint32(s.HeatMode),
+		HeatSetPoint: s.HeatSetPoint,
+	}
+
+	if s.Type == ScheduleTypeRunOnce {
+		event.Flags |= scheduleFlagRunOnce
+	}
+
+	if s.UpdateHeatSetPoint {
+		event.Flags |= scheduleFlagHeatSetPoint
+	}
+
+	return event
+}
@@ -0,0 +1,163 @@
+package screenlogic
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+	"github.com/brutella/hc/log"
+)
+
+// OOBHandler - called with packets the gateway sends us without being asked (out of band), like
+// status change notifications. data is the packet body, and is the handler's to keep.
+//
+// The gateway only sends these while we're waiting on a reply to something else, and handlers are
+// called right in the middle of reading that reply, so they must not use the gateway themselves.
+// That request can come from any goroutine, including the keepalive's pings, so handlers must do
+// their own locking for anything they share.
+type OOBHandler func(header *protocol.PacketHeader, data []byte)
+
+// Subscription - returned from Subscribe, use it to stop receiving packets.
+type Subscription struct {
+	gateway  *Gateway
+	typeCode uint16
+	id       uint64
+}
+
+type subscriptions struct {
+	mutex    sync.Mutex
+	nextID   uint64
+	handlers map[uint16]map[uint64]OOBHandler
+}
+
+// Subscribe - handler will be called for every out of band packet with typeCode. It's safe to subscribe
+// and unsubscribe at any time, from any goroutine, including from inside a handler.
+func (g *Gateway) Subscribe(typeCode uint16, handler OOBHandler) *Subscription {
+	g.subscriptions.mutex.Lock()
+	defer g.subscriptions.mutex.Unlock()
+
+	if g.subscriptions.handlers == nil {
+		g.subscriptions.handlers = make(map[uint16]map[uint64]OOBHandler)
+	}
+
+	if g.subscriptions.handlers[typeCode] == nil {
+		g.subscriptions.handlers[typeCode] = make(map[uint64]OOBHandler)
+	}
+
+	g.subscriptions.nextID++
+	id := g.subscriptions.nextID
+
+	g.subscriptions.handlers[typeCode][id] = handler
+
+	return &Subscription{gateway: g, typeCode: typeCode, id: id}
+}
+
+// Unsubscribe - stops calling the handler. Calling this more than once is fine.
+func (s *Subscription) Unsubscribe() {
+	subs := &s.gateway.subscriptions
+
+	subs.mutex.Lock()
+	defer subs.mutex.Unlock()
+
+	delete(subs.handlers[s.typeCode], s.id)
+}
+
+// dispatchOOBPacket - returns false if nobody was subscribed to this packet.
+func (g *Gateway) dispatchOOBPacket(header *protocol.PacketHeader, data *bytes.Buffer) bool {
+	g.subscriptions.mutex.Lock()
+
+	// Copy the handlers out so they're free to (un)subscribe while we call them.
+	handlers := make([]OOBHandler, 0, len(g.subscriptions.handlers[header.TypeID]))
+	for _, handler := range g.subscriptions.handlers[header.TypeID] {
+		handlers = append(handlers, handler)
+	}
+
+	g.subscriptions.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(header, append([]byte(nil), data.Bytes()...))
+	}
+
+	return len(handlers) > 0
+}
+
+// subscribeDecoded - subscribes to typeCode, decoding each packet with newPacket before calling fn.
+func subscribeDecoded(g *Gateway, typeCode uint16, newPacket func() protocol.ReadablePacket, fn func(protocol.ReadablePacket)) *Subscription {
+	return g.Subscribe(typeCode, func(header *protocol.PacketHeader, data []byte) {
+		p := newPacket()
+
+		err := p.Decode(header, bytes.NewBuffer(data))
+		if err != nil {
+			log.Info.Printf("unable to decode OOB packet with type code %v: %v\n", header.TypeID, err)
+			return
+		}
+
+		fn(p)
+	})
+}
+
+// OnStatusChanged - fn is called with the new status whenever the gateway pushes one. The gateway
+// only does this after AddClient.
+func (g *Gateway) OnStatusChanged(fn func(status *PoolStatus)) *Subscription {
+	return subscribeDecoded(g, protocol.StatusChangedPacketCode,
+		func() protocol.ReadablePacket { return &PoolStatus{} },
+		func(p protocol.ReadablePacket) { fn(p.(*PoolStatus)) },
+	)
+}
+
+// OnChemistryChanged - fn is called whenever the chemistry controller has new readings.
+func (g *Gateway) OnChemistryChanged(fn func(packet *protocol.ChemistryChangedPacket)) *Subscription {
+	return subscribeDecoded(g, protocol.ChemistryChangedPacketCode,
+		func() protocol.ReadablePacket { return &protocol.ChemistryChangedPacket{} },
+		func(p protocol.ReadablePacket) { fn(p.(*protocol.ChemistryChangedPacket)) },
+	)
+}
+
+// OnColorUpdate - fn is called as color lights change.
+func (g *Gateway) OnColorUpdate(fn func(packet *protocol.ColorUpdatePacket)) *Subscription {
+	return subscribeDecoded(g, protocol.ColorUpdatePacketCode,
+		func() protocol.ReadablePacket { return &protocol.ColorUpdatePacket{} },
+		func(p protocol.ReadablePacket) { fn(p.(*protocol.ColorUpdatePacket)) },
+	)
+}
+
+// OnWeatherForecastChanged - fn is called whenever the gateway tells us it has a new forecast. Call
+// WeatherForecast to get it.
+func (g *Gateway) OnWeatherForecastChanged(fn func()) *Subscription {
+	return g.Subscribe(protocol.WeatherForcastChangedCode, func(header *protocol.PacketHeader, data []byte) {
+		fn()
+	})
+}
+
+// AddClient - asks the gateway to push status changes to this connection. clientID can be any number,
+// and is only used to remove the client again.
+func (g *Gateway) AddClient(clientID uint32) error {
+	var req protocol.AddClientPacket
+	req.ControllerIndex = 0
+	req.ClientID = clientID
+
+	var resp protocol.AddClientResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveClient - stops the gateway pushing status changes to this connection.
+func (g *Gateway) RemoveClient(clientID uint32) error {
+	var req protocol.RemoveClientPacket
+	req.ControllerIndex = 0
+	req.ClientID = clientID
+
+	var resp protocol.RemoveClientResponsePacket
+
+	err := g.roundTrip(&req, &resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package screenlogic
+
+type TemperatureUnit string
+
+const (
+	Celsius    TemperatureUnit = "C"
+	Fahrenheit TemperatureUnit = "F"
+)
+
+// Units - the temperature unit the controller reports all of its temperatures in.
+func (cc *ControllerConfiguration) Units() TemperatureUnit {
+	if cc.IsCelcius {
+		return Celsius
+	}
+
+	return Fahrenheit
+}
+
+// ConvertTemperature - converts temp from one unit to another. If both units are
+// the same, temp is returned as-is.
+func ConvertTemperature(temp float64, from, to TemperatureUnit) float64 {
+	if from == to {
+		return temp
+	}
+
+	if to == Celsius {
+		return (temp - 32) * 5 / 9
+	}
+
+	return temp*9/5 + 32
+}
@@ -0,0 +1,41 @@
+package screenlogic
+
+import (
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic/protocol"
+)
+
+type WeatherForecast struct {
+	protocol.WeatherForecastResponsePacket
+}
+
+// SunriseOn - the time the sun rises on the same day as t, in t's location.
+func (wf *WeatherForecast) SunriseOn(t time.Time) time.Time {
+	return minutesPastMidnight(t, wf.Sunrise)
+}
+
+// SunsetOn - the time the sun sets on the same day as t, in t's location.
+func (wf *WeatherForecast) SunsetOn(t time.Time) time.Time {
+	return minutesPastMidnight(t, wf.Sunset)
+}
+
+func minutesPastMidnight(t time.Time, minutes uint32) time.Time {
+	year, month, day := t.Date()
+
+	return time.Date(year, month, day, 0, int(minutes), 0, 0, t.Location())
+}
+
+// WeatherForecast - the latest forecast the gateway has pulled down for its zip code.
+func (g *Gateway) WeatherForecast() (*WeatherForecast, error) {
+	var req protocol.WeatherForecastPacket
+
+	resp := &WeatherForecast{}
+
+	err := g.roundTrip(&req, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
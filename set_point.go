@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/brutella/hc/log"
+)
+
+// setPointWriter - coalesces set point changes from HomeKit. Dragging the slider in the Home app sends a new
+// value for every step it passes, each of which would otherwise be its own request to the controller. Only
+// the last value is sent, once no new ones have come in for delay.
+type setPointWriter struct {
+	name  string
+	delay time.Duration
+
+	// send sends a set point to the controller. If it fails, revert is called to put the characteristic
+	// back to the controller's actual set point, so HomeKit doesn't show one that was never applied.
+	send   func(celsius float64) error
+	revert func()
+
+	mutex sync.Mutex
+	timer *time.Timer
+
+	// generation goes up with every set(), so a timer that fires after a newer value came in knows
+	// it's stale and leaves the sending to the newer timer.
+	generation uint64
+	pending    float64
+}
+
+func newSetPointWriter(name string, delay time.Duration, send func(celsius float64) error, revert func()) *setPointWriter {
+	return &setPointWriter{
+		name:   name,
+		delay:  delay,
+		send:   send,
+		revert: revert,
+	}
+}
+
+// set - schedules celsius to be sent to the controller, replacing anything that hasn't been sent yet.
+func (spw *setPointWriter) set(celsius float64) {
+	spw.mutex.Lock()
+	defer spw.mutex.Unlock()
+
+	spw.pending = celsius
+	spw.generation++
+
+	if spw.timer != nil {
+		spw.timer.Stop()
+	}
+
+	generation := spw.generation
+
+	spw.timer = time.AfterFunc(spw.delay, func() {
+		spw.flush(generation)
+	})
+}
+
+func (spw *setPointWriter) flush(generation uint64) {
+	spw.mutex.Lock()
+
+	if generation != spw.generation {
+		// A newer value came in after this timer had already fired.
+		spw.mutex.Unlock()
+		return
+	}
+
+	celsius := spw.pending
+	spw.timer = nil
+
+	spw.mutex.Unlock()
+
+	err := spw.send(celsius)
+	if err != nil {
+		log.Info.Printf("%s: set point: %v\n", spw.name, err)
+
+		spw.revert()
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brutella/hc/characteristic"
+)
+
+func TestSetPointRoundTrip(t *testing.T) {
+	client := &Client{}
+
+	tests := []struct {
+		name        string
+		units       int
+		temperature uint32
+	}{
+		{"pool minimum, fahrenheit", characteristic.TemperatureDisplayUnitsFahrenheit, 40},
+		{"pool maximum, fahrenheit", characteristic.TemperatureDisplayUnitsFahrenheit, 104},
+		{"spa site maximum, fahrenheit", characteristic.TemperatureDisplayUnitsFahrenheit, 102},
+		{"just under the site maximum, fahrenheit", characteristic.TemperatureDisplayUnitsFahrenheit, 101},
+		{"pool minimum, celsius", characteristic.TemperatureDisplayUnitsCelsius, 4},
+		{"spa maximum, celsius", characteristic.TemperatureDisplayUnitsCelsius, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			celsius := float64(tt.temperature)
+			if tt.units == characteristic.TemperatureDisplayUnitsFahrenheit {
+				celsius = client.fahrenheitToCelsius(tt.temperature)
+			}
+
+			got := temperatureFromHomeKit(celsius, tt.units)
+			if got != tt.temperature {
+				t.Errorf("%d went to HomeKit as %v and came back as %d", tt.temperature, celsius, got)
+			}
+		})
+	}
+}
+
+func TestClampSetPoint(t *testing.T) {
+	tests := []struct {
+		temperature uint32
+		want        uint32
+	}{
+		{39, 40},
+		{40, 40},
+		{84, 84},
+		{102, 102},
+		{103, 102},
+	}
+
+	for _, tt := range tests {
+		got := clampSetPoint(tt.temperature, 40, 102)
+		if got != tt.want {
+			t.Errorf("clampSetPoint(%d, 40, 102) = %d, want %d", tt.temperature, got, tt.want)
+		}
+	}
+}
+
+func TestSetPointWriterSendsLastValueOnce(t *testing.T) {
+	var mutex sync.Mutex
+	var sent []float64
+
+	spw := newSetPointWriter("pool", 20*time.Millisecond, func(celsius float64) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		sent = append(sent, celsius)
+
+		return nil
+	}, func() {
+		t.Error("revert called after a successful write")
+	})
+
+	for _, celsius := range []float64{30, 31, 32, 33, 34} {
+		spw.set(celsius)
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(sent) != 1 || sent[0] != 34 {
+		t.Errorf("sent %v, want exactly [34]", sent)
+	}
+}
+
+func TestSetPointWriterRevertsOnError(t *testing.T) {
+	reverted := make(chan struct{})
+
+	spw := newSetPointWriter("spa", time.Millisecond, func(celsius float64) error {
+		return errors.New("gateway went away")
+	}, func() {
+		close(reverted)
+	})
+
+	spw.set(38)
+
+	select {
+	case <-reverted:
+	case <-time.After(time.Second):
+		t.Error("set point wasn't reverted after the write failed")
+	}
+}
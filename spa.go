@@ -1,7 +1,12 @@
 package main
 
 import (
+	"time"
+
+	"github.com/brianmario/screenlogic-homekit/screenlogic"
 	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
 	"github.com/brutella/hc/service"
 )
 
@@ -11,14 +16,17 @@ type SpaAccessory struct {
 	heater     *WaterHeaterService
 	airBubbles *service.FanV2 // this may need to just be an on/off switch
 
+	statusActive *characteristic.StatusActive
+	setPoint     *setPointWriter
+
 	client *Client
 }
 
-func NewSpaAccessory(client *Client) *SpaAccessory {
+func NewSpaAccessory(client *Client, name string, manufacturer string, setPointDelay time.Duration) (*SpaAccessory, error) {
 	info := accessory.Info{
-		Name: "Hot Tub",
+		Name: name,
 		// Model: "",
-		Manufacturer: "PentAir",
+		Manufacturer: manufacturer,
 		// SerialNumber: "",
 		// FirmwareRevision: "",
 	}
@@ -32,17 +40,31 @@ func NewSpaAccessory(client *Client) *SpaAccessory {
 	spa.heater = NewWaterHeaterService()
 	spa.AddService(spa.heater.Service)
 
-	spa.heater.displayUnits.OnValueRemoteGet(spa.client.GetTemperatureDisplayUnits)
+	addStatusFault(spa.heater.Service, client)
+
+	// The controller holds equipment off for a while after some changes, like switching valves
+	// between the pool and spa. Show that as the heater not being active yet.
+	spa.statusActive = characteristic.NewStatusActive()
+	spa.statusActive.OnValueRemoteGet(remoteGetBool(name, spa.statusActive.Bool, client.GetSpaStatusActive))
+	spa.heater.AddCharacteristic(spa.statusActive.Characteristic)
+
+	spa.heater.displayUnits.OnValueRemoteGet(remoteGetInt(name, spa.heater.displayUnits.Int, client.GetTemperatureDisplayUnits))
+
+	allowedMin, allowedMax, err := client.getSetPointRange(screenlogic.BodyOfWaterSpa)
+	if err != nil {
+		return nil, err
+	}
 
-	controllerConfig, err := client.getControllerConfig()
+	min, err := client.convertTempToHomeKit(allowedMin)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
-	allowedSpaRange := controllerConfig.AllowedSpaSetPointRange
+	max, err := client.convertTempToHomeKit(allowedMax)
+	if err != nil {
+		return nil, err
+	}
 
-	min := client.convertTempToHomeKit(uint32(allowedSpaRange.Min))
-	max := client.convertTempToHomeKit(uint32(allowedSpaRange.Max))
 	step := float64(1.0)
 
 	// The current value must not be less than the minimum we configure, otherwise HomeKit will refuse
@@ -51,21 +73,39 @@ func NewSpaAccessory(client *Client) *SpaAccessory {
 	spa.heater.heatingThresholdTemperature.SetMinValue(min)
 	spa.heater.heatingThresholdTemperature.SetMaxValue(max)
 	spa.heater.heatingThresholdTemperature.SetStepValue(step)
-	spa.heater.heatingThresholdTemperature.OnValueRemoteGet(spa.client.GetSpaHeatingThresholdTemp)
+	spa.heater.heatingThresholdTemperature.OnValueRemoteGet(remoteGetFloat(name, spa.heater.heatingThresholdTemperature.Float, client.GetSpaHeatingThresholdTemp))
+
+	threshold := spa.heater.heatingThresholdTemperature
+
+	spa.setPoint = newSetPointWriter(name, setPointDelay, func(celsius float64) error {
+		return client.setHomeKitSetPoint(screenlogic.BodyOfWaterSpa, celsius)
+	}, func() {
+		celsius, err := client.getHomeKitSetPoint(screenlogic.BodyOfWaterSpa)
+		if err != nil {
+			log.Info.Printf("%s: unable to restore set point: %v\n", name, err)
+			return
+		}
 
-	spa.heater.HeaterCooler.Active.OnValueRemoteGet(spa.client.GetSpaHeaterActive)
+		threshold.SetValue(celsius)
+	})
+	spa.heater.heatingThresholdTemperature.OnValueRemoteUpdate(spa.setPoint.set)
 
-	spa.heater.HeaterCooler.CurrentHeaterCoolerState.OnValueRemoteGet(spa.client.GetSpaCurrentHeatingState)
+	spa.heater.HeaterCooler.Active.OnValueRemoteGet(remoteGetInt(name, spa.heater.HeaterCooler.Active.Int, client.GetSpaHeaterActive))
 
-	spa.heater.HeaterCooler.TargetHeaterCoolerState.OnValueRemoteGet(spa.client.GetSpaTargetHeatingState)
+	spa.heater.HeaterCooler.CurrentHeaterCoolerState.OnValueRemoteGet(remoteGetInt(name, spa.heater.HeaterCooler.CurrentHeaterCoolerState.Int, client.GetSpaCurrentHeatingState))
 
-	currentTemp := client.GetCurrentSpaTemp()
+	spa.heater.HeaterCooler.TargetHeaterCoolerState.OnValueRemoteGet(remoteGetInt(name, spa.heater.HeaterCooler.TargetHeaterCoolerState.Int, client.GetSpaTargetHeatingState))
+
+	currentTemp, err := client.GetCurrentSpaTemp()
+	if err != nil {
+		return nil, err
+	}
 
 	spa.heater.HeaterCooler.CurrentTemperature.SetValue(currentTemp)
-	spa.heater.HeaterCooler.CurrentTemperature.OnValueRemoteGet(spa.client.GetCurrentSpaTemp)
+	spa.heater.HeaterCooler.CurrentTemperature.OnValueRemoteGet(remoteGetFloat(name, spa.heater.HeaterCooler.CurrentTemperature.Float, client.GetCurrentSpaTemp))
 
 	spa.airBubbles = service.NewFanV2()
 	spa.AddService(spa.airBubbles.Service)
 
-	return spa
+	return spa, nil
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/service"
+)
+
+// StatusSensorAccessory - an occupancy sensor that's "occupied" while some controller condition is active,
+// like freeze protection. HomeKit has no better fit for this, and occupancy sensors can drive automations
+// and notifications.
+type StatusSensorAccessory struct {
+	*accessory.Accessory
+
+	sensor *service.OccupancySensor
+
+	client *Client
+}
+
+func NewStatusSensorAccessory(client *Client, name string, manufacturer string, detected func() (int, error)) (*StatusSensorAccessory, error) {
+	info := accessory.Info{
+		Name:         name,
+		Manufacturer: manufacturer,
+	}
+
+	sensor := &StatusSensorAccessory{
+		Accessory: accessory.New(info, accessory.TypeSensor),
+
+		client: client,
+	}
+
+	sensor.sensor = service.NewOccupancySensor()
+	sensor.AddService(sensor.sensor.Service)
+
+	value, err := detected()
+	if err != nil {
+		return nil, err
+	}
+
+	sensor.sensor.OccupancyDetected.SetValue(value)
+	sensor.sensor.OccupancyDetected.OnValueRemoteGet(remoteGetInt(name, sensor.sensor.OccupancyDetected.Int, detected))
+
+	addStatusFault(sensor.sensor.Service, client)
+
+	return sensor, nil
+}
+
+// addStatusFault - adds a StatusFault characteristic to svc that reports a fault whenever the controller
+// isn't ready or can't be reached, so HomeKit flags the accessory instead of showing stale values as if they
+// were current.
+func addStatusFault(svc *service.Service, client *Client) {
+	fault := characteristic.NewStatusFault()
+	fault.OnValueRemoteGet(client.GetStatusFault)
+
+	svc.AddCharacteristic(fault.Characteristic)
+}
@@ -0,0 +1,305 @@
+// Dashboard for screenlogic-homekit. Everything here goes through the bridge's own HTTP API,
+// which is served one level up from the dashboard.
+(function () {
+  "use strict";
+
+  const api = "../";
+  const heatModes = ["off", "solar", "solar-preferred", "on"];
+  const bodies = ["pool", "spa"];
+
+  let config = null;
+  let units = "°F";
+
+  function $(selector, root) {
+    return (root || document).querySelector(selector);
+  }
+
+  function showError(err) {
+    const footer = $("#error");
+
+    if (!err) {
+      footer.hidden = true;
+      return;
+    }
+
+    footer.textContent = String(err);
+    footer.hidden = false;
+  }
+
+  async function request(method, path, body) {
+    const opts = { method: method, headers: {} };
+
+    if (body !== undefined) {
+      opts.headers["Content-Type"] = "application/json";
+      opts.body = JSON.stringify(body);
+    }
+
+    const resp = await fetch(api + path, opts);
+
+    if (!resp.ok) {
+      let message = resp.statusText;
+
+      try {
+        message = (await resp.json()).error || message;
+      } catch (e) {
+        // not JSON, stick with the status text
+      }
+
+      throw new Error(method + " " + path + ": " + message);
+    }
+
+    if (resp.status === 204) {
+      return null;
+    }
+
+    return resp.json();
+  }
+
+  function controllerState(ok) {
+    switch (ok) {
+      case 1: return ["Ready", "ok"];
+      case 2: return ["Syncing", ""];
+      case 3: return ["Service mode", "warning"];
+      default: return ["Unknown", ""];
+    }
+  }
+
+  function renderStatus(status) {
+    const [stateText, stateClass] = controllerState(status.OK);
+    const state = $("#controller-state");
+    state.textContent = stateText;
+    state.className = "badge " + stateClass;
+
+    $("#freeze-mode").hidden = !status.FreezeMode;
+    $("#air-temp").textContent = status.AirTemp + units;
+
+    bodies.forEach(function (name, idx) {
+      const card = $("#" + name);
+      const body = (status.Bodies || [])[idx];
+
+      card.hidden = !body;
+      if (!body) {
+        return;
+      }
+
+      $(".current", card).textContent = body.CurrentTemp + units;
+
+      const heater = $(".heater-state", card);
+      heater.textContent = body.HeaterStatus ? "running" : "idle";
+      heater.classList.toggle("on", !!body.HeaterStatus);
+
+      // Don't clobber a set point the user is in the middle of typing.
+      const input = $("input[name=temperature]", card);
+      if (document.activeElement !== input) {
+        input.value = body.HeatSetPoint;
+      }
+
+      $(".heatmode", card).value = heatModes[body.HeatMode] || "off";
+    });
+
+    const chem = status.Chemistry || {};
+    $("#chem-ph").textContent = chem.PH ? chem.PH.toFixed(2) : "–";
+    $("#chem-orp").textContent = chem.ORP ? Math.round(chem.ORP) + " mV" : "–";
+    $("#chem-salt").textContent = chem.SaltPPM ? chem.SaltPPM + " ppm" : "–";
+    $("#chem-saturation").textContent = chem.PH ? chem.Saturation.toFixed(2) : "–";
+
+    renderCircuits(status.Circuits || []);
+  }
+
+  function renderCircuits(circuits) {
+    const list = $("#circuit-list");
+    const states = {};
+
+    circuits.forEach(function (c) {
+      states[c.ID] = !!c.ValveState;
+    });
+
+    list.textContent = "";
+
+    (config.Circuits || []).forEach(function (circuit) {
+      if (!(circuit.ID in states)) {
+        return;
+      }
+
+      const on = states[circuit.ID];
+      const item = document.createElement("li");
+      const button = document.createElement("button");
+
+      button.textContent = circuit.Name;
+      button.classList.toggle("on", on);
+      button.addEventListener("click", function () {
+        button.disabled = true;
+
+        request("PUT", "circuits/" + circuit.ID, { on: !on })
+          .then(refresh)
+          .catch(showError)
+          .finally(function () { button.disabled = false; });
+      });
+
+      item.appendChild(button);
+      list.appendChild(item);
+    });
+  }
+
+  function setupBodyControls() {
+    bodies.forEach(function (name) {
+      const card = $("#" + name);
+      const range = name === "pool" ? config.AllowedPoolSetPointRange : config.AllowedSpaSetPointRange;
+      const input = $("input[name=temperature]", card);
+
+      input.min = range.Min;
+      input.max = range.Max;
+
+      $("form.setpoint", card).addEventListener("submit", function (ev) {
+        ev.preventDefault();
+
+        request("PUT", "bodies/" + name + "/setpoint", { temperature: parseInt(input.value, 10) })
+          .then(refresh)
+          .catch(showError);
+      });
+
+      $(".heatmode", card).addEventListener("change", function (ev) {
+        request("PUT", "bodies/" + name + "/heatmode", { mode: ev.target.value })
+          .then(refresh)
+          .catch(showError);
+      });
+    });
+  }
+
+  function svg(tag, attrs) {
+    const el = document.createElementNS("http://www.w3.org/2000/svg", tag);
+
+    Object.keys(attrs).forEach(function (k) {
+      el.setAttribute(k, attrs[k]);
+    });
+
+    return el;
+  }
+
+  function renderHistory(history, start, end) {
+    const chart = $("#history-chart");
+    const width = 800;
+    const height = 240;
+    const span = end.getTime() - start.getTime();
+
+    const series = {
+      outside: history.OutsideTemps || [],
+      pool: history.PoolWaterTemps || [],
+      spa: history.HotTubWaterTemps || [],
+    };
+
+    let min = Infinity;
+    let max = -Infinity;
+
+    Object.keys(series).forEach(function (k) {
+      series[k].forEach(function (e) {
+        min = Math.min(min, e.Temp);
+        max = Math.max(max, e.Temp);
+      });
+    });
+
+    chart.textContent = "";
+
+    if (min === Infinity) {
+      const empty = svg("text", { x: width / 2, y: height / 2, "text-anchor": "middle" });
+      empty.textContent = "No history yet";
+      chart.appendChild(empty);
+      return;
+    }
+
+    // A little headroom so lines don't sit right on the edges.
+    min -= 2;
+    max += 2;
+
+    const x = function (t) { return ((new Date(t).getTime() - start.getTime()) / span) * width; };
+    const y = function (temp) { return height - ((temp - min) / (max - min)) * height; };
+
+    (history.HeaterRuns || []).forEach(function (run) {
+      const x1 = Math.max(0, x(run.Start));
+      const x2 = Math.min(width, x(run.Stop));
+
+      chart.appendChild(svg("rect", { class: "heater", x: x1, y: 0, width: Math.max(1, x2 - x1), height: height }));
+    });
+
+    Object.keys(series).forEach(function (k) {
+      if (series[k].length === 0) {
+        return;
+      }
+
+      const points = series[k].map(function (e) {
+        return x(e.Timestamp).toFixed(1) + "," + y(e.Temp).toFixed(1);
+      });
+
+      chart.appendChild(svg("polyline", { class: k, points: points.join(" ") }));
+    });
+
+    const top = svg("text", { x: 4, y: 14 });
+    top.textContent = Math.round(max) + units;
+    chart.appendChild(top);
+
+    const bottom = svg("text", { x: 4, y: height - 4 });
+    bottom.textContent = Math.round(min) + units;
+    chart.appendChild(bottom);
+  }
+
+  function loadHistory() {
+    const end = new Date();
+    const start = new Date(end.getTime() - 24 * 60 * 60 * 1000);
+    const query = "from=" + encodeURIComponent(start.toISOString()) + "&to=" + encodeURIComponent(end.toISOString());
+
+    return request("GET", "history?" + query).then(function (history) {
+      renderHistory(history, start, end);
+    });
+  }
+
+  function refresh() {
+    return request("GET", "status").then(function (status) {
+      renderStatus(status);
+      showError(null);
+    });
+  }
+
+  function listen() {
+    const events = new EventSource(api + "events");
+
+    events.addEventListener("status", function (ev) {
+      renderStatus(JSON.parse(ev.data));
+      showError(null);
+    });
+
+    events.addEventListener("change", function (ev) {
+      renderStatus(JSON.parse(ev.data).status);
+      showError(null);
+    });
+
+    // The bridge sends an "error" event of its own when it can't reach the gateway. Otherwise the
+    // connection to the bridge dropped, and EventSource reconnects on its own, just let the user know
+    // in the meantime.
+    events.addEventListener("error", function (ev) {
+      if (ev.data) {
+        showError("Can't reach the gateway: " + JSON.parse(ev.data).error);
+        return;
+      }
+
+      showError("Lost connection to the bridge, reconnecting…");
+    });
+  }
+
+  async function start() {
+    try {
+      config = await request("GET", "config");
+      units = config.IsCelcius ? "°C" : "°F";
+
+      setupBodyControls();
+      await refresh();
+      listen();
+
+      await loadHistory();
+      setInterval(function () { loadHistory().catch(showError); }, 15 * 60 * 1000);
+    } catch (err) {
+      showError(err);
+    }
+  }
+
+  start();
+})();